@@ -1,10 +1,24 @@
 package config
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/geo-gkez/go-pocs/redpanda-poc/internal/config/logger"
 	"github.com/geo-gkez/go-pocs/redpanda-poc/internal/config/models"
+	"github.com/geo-gkez/go-pocs/redpanda-poc/internal/metrics"
 	"github.com/geo-gkez/go-pocs/redpanda-poc/internal/routes"
 	"github.com/geo-gkez/go-pocs/redpanda-poc/internal/service"
 	"github.com/gin-gonic/gin"
@@ -16,47 +30,283 @@ type Settings struct {
 	KafkaClient *kgo.Client
 }
 
+// defaultShutdownTimeout is used when server.shutdown_timeout is not configured
+const defaultShutdownTimeout = 30 * time.Second
+
+// defaultProduceBufferThreshold is used when server.readiness.produce_buffer_threshold is not configured
+const defaultProduceBufferThreshold = 10000
+
+// defaultMaxConcurrentTails is used when server.tail.max_concurrent is not configured
+const defaultMaxConcurrentTails = 5
+
 func SetupApp() {
-	config, err := loadConfig()
+	options, err := parseConfigOptions(os.Args[1:])
+	if err != nil {
+		panic(fmt.Sprintf("failed to parse command-line flags: %v", err))
+	}
+
+	config, configFiles, err := loadConfig(options)
 	if err != nil {
 		panic(fmt.Sprintf("failed to load config: %v", err))
 	}
 
-	kafkaClient := setUpKafka(config, service.ProcessKafkaMessage)
+	logger.InitLogger(logger.LoggerConfig{Level: config.Server.LogLevel})
 
-	kafkaService := service.NewKafkaService(kafkaClient)
+	if config.Server.Mode != "" {
+		gin.SetMode(config.Server.Mode)
+	}
 
-	router := gin.Default()
+	// Cancelled on SIGINT / SIGTERM to start the graceful shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-	router = routes.SetupRoutesAndRegister(router, kafkaService)
+	app := newApp(config, messageHandlers(config))
+	app.live.watch(options, configFiles)
 
 	serverPort := config.Server.Port
-	router.Run(fmt.Sprintf(":%d", serverPort))
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", serverPort),
+		Handler: app.router,
+	}
+
+	// Live tails stream until the client disconnects, so end them when shutting down
+	server.RegisterOnShutdown(app.records.StopTails)
+
+	slog.Info("starting HTTP server", "port", serverPort)
+
+	serverErr := make(chan error, 1)
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serverErr <- err
+		}
+	}()
+
+	select {
+	case <-ctx.Done():
+		slog.Info("shutdown signal received, shutting down")
+	case err := <-serverErr:
+		slog.Error("HTTP server failed, shutting down", "error", err)
+	}
+
+	shutdown(server, app.kafka, config.Server.ShutdownTimeout)
 }
 
-func loadConfig() (*config_models.AppConfiguration, error) {
-	viper.SetConfigName("config")
-	viper.SetConfigType("yaml")
+// app is the wired application: the Kafka runtime consuming with the registered handlers
+// and the router serving the HTTP API. It does not listen by itself, so it can be served
+// by an http.Server or an httptest.Server.
+type app struct {
+	kafka   *kafkaRuntime
+	live    *liveConfig
+	records service.IRecordService
+	router  *gin.Engine
+}
 
-	// Get the project root directory
-	projectRoot, err := os.Getwd()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get working directory: %w", err)
+// newApp connects to Kafka, starts consuming the topics of handlers and sets up the routes
+func newApp(config *config_models.AppConfiguration, handlers *HandlerRegistry) *app {
+	appMetrics := metrics.New()
+
+	kafka := setUpKafka(config, handlers, appMetrics)
+
+	kafkaService := service.NewKafkaService(kafka.client, config.Kafka.Topics)
+	rateLimiter := routes.NewRateLimiter(config.Server.RateLimit)
+
+	// Apply the settings that can change at runtime now and on every configuration change
+	live := newLiveConfig(config, func(config *config_models.AppConfiguration) {
+		logger.SetLevel(config.Server.LogLevel)
+		rateLimiter.Update(config.Server.RateLimit)
+		kafkaService.SetProduceTimeout(config.Kafka.Producer.Timeout)
+		kafka.deadLetter.setRetryPolicy(config.Kafka.Consumer.Retry)
+	})
+
+	router := gin.New()
+	router.Use(gin.Recovery(), logger.GinMiddleware(), appMetrics.GinMiddleware())
+
+	router = routes.SetupRoutesAndRegister(router, kafkaService, rateLimiter)
+
+	healthService := service.NewHealthService(kafka.client, kafka.group(), readinessBufferThreshold(config.Server.Readiness))
+	router = routes.SetupHealthRoutes(router, healthService)
+	recordService := service.NewRecordService(kafka.client, kafka.newClient, maxConcurrentTails(config.Server.Tail))
+	router = routes.SetupRecordRoutes(router, recordService)
+	router = routes.SetupMetricsRoutes(router, appMetrics.Handler())
+
+	// The admin API can delete topics and move group offsets, so it is only served when enabled
+	if admin := config.Server.Admin; admin.Enabled {
+		adminService := service.NewAdminService(kafka.client, protectedTopics(config.Kafka.Topics)...)
+		router = routes.SetupAdminRoutes(router, adminService, admin.Token, live.Redacted)
+	}
+
+	return &app{
+		kafka:   kafka,
+		live:    live,
+		records: recordService,
+		router:  router,
+	}
+}
+
+// messageHandlers registers the handler of every consumed topic. Consuming another topic
+// only takes one more Handle or HandleRegex call here, or HandleTransactional in
+// transactional mode.
+func messageHandlers(config *config_models.AppConfiguration) *HandlerRegistry {
+	handlers := NewHandlerRegistry()
+	if config.Kafka.Transactions.Enabled {
+		handlers.HandleTransactional(config.Kafka.Topics.DefaultConsumer, service.TransformKafkaMessage)
+	} else {
+		handlers.Handle(config.Kafka.Topics.DefaultConsumer, service.ProcessKafkaMessage)
 	}
 
-	// Try multiple possible config locations
-	viper.AddConfigPath(fmt.Sprintf("%s/configs", projectRoot)) // From project root
-	viper.AddConfigPath("configs")                              // Direct subfolder
-	viper.AddConfigPath("../../configs")                        // Two levels up
+	return handlers
+}
 
-	if err := viper.ReadInConfig(); err != nil {
-		return nil, fmt.Errorf("failed to read config file: %w", err)
+// protectedTopics returns the topics the application produces to, consumes from or declares,
+// which cannot be deleted through the admin API
+func protectedTopics(topics config_models.KafkaTopics) []string {
+	protected := []string{topics.DefaultProducer, topics.DefaultConsumer}
+	if topics.DeadLetter != "" {
+		protected = append(protected, topics.DeadLetter)
+	}
+	protected = append(protected, topics.AllowedProducerTopics...)
+	for _, definition := range topics.Definitions {
+		protected = append(protected, definition.Name)
+	}
+
+	slices.Sort(protected)
+	return slices.Compact(protected)
+}
+
+// readinessBufferThreshold returns the number of buffered produce records at which the service stops being ready
+func readinessBufferThreshold(readiness config_models.ReadinessConfiguration) int64 {
+	if readiness.ProduceBufferThreshold <= 0 {
+		return defaultProduceBufferThreshold
+	}
+	return int64(readiness.ProduceBufferThreshold)
+}
+
+// maxConcurrentTails returns the number of live tails that may run at the same time
+func maxConcurrentTails(tail config_models.TailConfiguration) int {
+	if tail.MaxConcurrent <= 0 {
+		return defaultMaxConcurrentTails
+	}
+	return tail.MaxConcurrent
+}
+
+// shutdown stops accepting HTTP requests and waits for the in-flight ones, then shuts
+// Kafka down, all within the configured shutdown timeout
+func shutdown(server *http.Server, kafka *kafkaRuntime, timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Error("failed to shut down HTTP server", "error", err)
+	}
+
+	if err := kafka.shutdown(ctx); err != nil {
+		slog.Error("failed to shut down Kafka cleanly", "error", err)
+	}
+
+	slog.Info("shutdown complete")
+}
+
+// envPrefix prefixes the environment variables overriding configuration keys, e.g.
+// REDPANDA_POC_KAFKA_CONNECTION_BROKERS for kafka.connection.brokers
+const envPrefix = "REDPANDA_POC"
+
+// configOptions selects the configuration file and the profile overlay to load
+type configOptions struct {
+	// file is an explicit path to the base configuration file
+	file string
+	// profile selects the overlay file merged over the base one, e.g. config.prod.yml for "prod"
+	profile string
+}
+
+// parseConfigOptions reads the --config and --profile flags, falling back to the
+// REDPANDA_POC_CONFIG and REDPANDA_POC_PROFILE environment variables
+func parseConfigOptions(args []string) (configOptions, error) {
+	options := configOptions{
+		file:    os.Getenv(envPrefix + "_CONFIG"),
+		profile: os.Getenv(envPrefix + "_PROFILE"),
+	}
+
+	flags := flag.NewFlagSet("redpanda-poc", flag.ContinueOnError)
+	flags.StringVar(&options.file, "config", options.file, "path to the configuration file")
+	flags.StringVar(&options.profile, "profile", options.profile, "configuration profile merged over the base file, e.g. prod for config.prod.yml")
+
+	if err := flags.Parse(args); err != nil {
+		return configOptions{}, err
+	}
+
+	return options, nil
+}
+
+// loadConfig reads, merges and validates the configuration and returns it together with
+// the files it was read from, the base file first
+func loadConfig(options configOptions) (*config_models.AppConfiguration, []string, error) {
+	v := viper.New()
+	v.SetConfigType("yaml")
+
+	if options.file != "" {
+		v.SetConfigFile(options.file)
+	} else {
+		v.SetConfigName("config")
+
+		// Get the project root directory
+		projectRoot, err := os.Getwd()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get working directory: %w", err)
+		}
+
+		// Try multiple possible config locations
+		v.AddConfigPath(fmt.Sprintf("%s/configs", projectRoot)) // From project root
+		v.AddConfigPath("configs")                              // Direct subfolder
+		v.AddConfigPath("../../configs")                        // Two levels up
+	}
+
+	if err := v.ReadInConfig(); err != nil {
+		return nil, nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	files := []string{v.ConfigFileUsed()}
+
+	if options.profile != "" {
+		overlay := profileConfigFile(v.ConfigFileUsed(), options.profile)
+		v.SetConfigFile(overlay)
+		if err := v.MergeInConfig(); err != nil {
+			return nil, nil, fmt.Errorf("failed to merge %q profile config file %s: %w", options.profile, overlay, err)
+		}
+		files = append(files, overlay)
+	}
+
+	// Environment variables override both files: kafka.topics.default-producer is read
+	// from REDPANDA_POC_KAFKA_TOPICS_DEFAULT_PRODUCER
+	v.SetEnvPrefix(envPrefix)
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_", "-", "_"))
+	v.AutomaticEnv()
+
+	// AutomaticEnv only covers keys present in the files, so every key is bound explicitly,
+	// e.g. credentials and TLS settings that are usually left out of them
+	for _, key := range settingKeys(reflect.TypeOf(config_models.AppConfiguration{}), "") {
+		if err := v.BindEnv(key); err != nil {
+			return nil, nil, fmt.Errorf("failed to bind environment variable of %s: %w", key, err)
+		}
 	}
 
 	var config config_models.AppConfiguration
-	if err := viper.Unmarshal(&config); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
+	if err := v.Unmarshal(&config); err != nil {
+		return nil, nil, fmt.Errorf("failed to unmarshal config: %w", err)
 	}
 
-	return &config, nil
+	if err := validateConfig(&config); err != nil {
+		return nil, nil, err
+	}
+
+	return &config, files, nil
+}
+
+// profileConfigFile returns the overlay file of a profile next to the base file,
+// e.g. configs/config.prod.yml for configs/config.yml and profile "prod"
+func profileConfigFile(base string, profile string) string {
+	ext := filepath.Ext(base)
+	return fmt.Sprintf("%s.%s%s", strings.TrimSuffix(base, ext), profile, ext)
 }
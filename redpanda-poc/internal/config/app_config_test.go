@@ -0,0 +1,567 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/geo-gkez/go-pocs/redpanda-poc/internal/config/models"
+	"github.com/geo-gkez/go-pocs/redpanda-poc/internal/model"
+	"github.com/gin-gonic/gin"
+	"github.com/twmb/franz-go/pkg/kadm"
+	"github.com/twmb/franz-go/pkg/kfake"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// testConfigFile is the configuration the integration tests boot the application with;
+// the brokers are filled in with the address of the fake cluster
+const testConfigFile = `
+server:
+  port: 8080
+  mode: test
+  log_level: warn
+  admin:
+    enabled: true
+    token: test-admin-token
+kafka:
+  connection:
+    brokers:
+      - %s
+  topics:
+    default-producer: test.output
+    default-consumer: test.input
+    default-consumer-group: test.group
+    allowed-producer-topics:
+      - test.input
+    dead-letter: test.input.dlq
+    definitions:
+      - name: test.input
+        partitions: 3
+      - name: test.audit
+        partitions: 2
+        configs:
+          cleanup.policy: compact
+  consumer:
+    middleware:
+      - recovery
+    retry:
+      max-retries: 0
+`
+
+// testTransactionsConfig is appended to testConfigFile to boot the application in transactional mode
+const testTransactionsConfig = `
+  transactions:
+    enabled: true
+    transactional-id: test.transactional
+`
+
+// testServer is the application booted against a fake cluster and served by httptest
+type testServer struct {
+	*httptest.Server
+	app   *app
+	token string // admin bearer token sent with every request, none when empty
+
+	stopKafka sync.Once
+}
+
+// startTestServer loads the test configuration and boots the application with the given
+// handler consuming the default consumer topic
+func startTestServer(t *testing.T, cluster *kfake.Cluster, handler MessageHandler) *testServer {
+	t.Helper()
+
+	return bootTestServer(t, cluster, "", func(handlers *HandlerRegistry, topic string) {
+		handlers.Handle(topic, handler)
+	})
+}
+
+// startTransactionalTestServer boots the application in transactional mode with the given
+// handler transforming the default consumer topic
+func startTransactionalTestServer(t *testing.T, cluster *kfake.Cluster, handler TransformHandler) *testServer {
+	t.Helper()
+
+	return bootTestServer(t, cluster, testTransactionsConfig, func(handlers *HandlerRegistry, topic string) {
+		handlers.HandleTransactional(topic, handler)
+	})
+}
+
+// bootTestServer loads the test configuration followed by extraConfig and boots the
+// application with the handlers registered by register for the default consumer topic
+func bootTestServer(t *testing.T, cluster *kfake.Cluster, extraConfig string, register func(handlers *HandlerRegistry, topic string)) *testServer {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	file := filepath.Join(t.TempDir(), "config.yml")
+	if err := os.WriteFile(file, []byte(fmt.Sprintf(testConfigFile, cluster.ListenAddrs()[0])+extraConfig), 0o600); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+
+	config, _, err := loadConfig(configOptions{file: file})
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	handlers := NewHandlerRegistry()
+	register(handlers, config.Kafka.Topics.DefaultConsumer)
+
+	app := newApp(config, handlers)
+	server := &testServer{Server: httptest.NewServer(app.router), app: app, token: config.Server.Admin.Token}
+
+	t.Cleanup(func() {
+		server.Close()
+		app.records.StopTails()
+		server.shutdownKafka(t)
+	})
+
+	return server
+}
+
+// shutdownKafka shuts the application's Kafka runtime down, leaving the consumer group.
+// It only does so once, so a test may take a member out of the group before the cleanup.
+func (s *testServer) shutdownKafka(t *testing.T) {
+	t.Helper()
+
+	s.stopKafka.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := s.app.kafka.shutdown(ctx); err != nil {
+			t.Errorf("failed to shut down Kafka: %v", err)
+		}
+	})
+}
+
+// do sends a JSON request and decodes the JSON response into out, returning the status code
+func (s *testServer) do(t *testing.T, method string, path string, body any, out any) int {
+	t.Helper()
+
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			t.Fatalf("failed to encode request: %v", err)
+		}
+	}
+
+	req, err := http.NewRequest(method, s.URL+path, &payload)
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+
+	resp, err := s.Client().Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("failed to decode response of %s %s: %v", method, path, err)
+		}
+	}
+
+	return resp.StatusCode
+}
+
+// recordCollector is a message handler remembering every record it processed
+type recordCollector struct {
+	mu      sync.Mutex
+	records map[string]*kgo.Record // by partition/offset, so redeliveries count once
+}
+
+func newRecordCollector() *recordCollector {
+	return &recordCollector{records: make(map[string]*kgo.Record)}
+}
+
+func (c *recordCollector) handle(_ context.Context, rec *kgo.Record) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.records[fmt.Sprintf("%d/%d", rec.Partition, rec.Offset)] = rec
+	return nil
+}
+
+func (c *recordCollector) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.records)
+}
+
+func (c *recordCollector) find(key string) (*kgo.Record, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, rec := range c.records {
+		if string(rec.Key) == key {
+			return rec, true
+		}
+	}
+	return nil, false
+}
+
+func TestLoadConfigEnvironmentOverrides(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yml")
+	if err := os.WriteFile(file, []byte(fmt.Sprintf(testConfigFile, "localhost:9092")), 0o600); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+
+	// keys present in the file as well as keys absent from it
+	t.Setenv("REDPANDA_POC_KAFKA_TOPICS_DEFAULT_PRODUCER", "env.output")
+	t.Setenv("REDPANDA_POC_SERVER_SHUTDOWN_TIMEOUT", "45s")
+	t.Setenv("REDPANDA_POC_KAFKA_CONNECTION_TLS_SERVER_NAME", "redpanda.internal")
+	t.Setenv("REDPANDA_POC_KAFKA_CONNECTION_SASL_PASSWORD_FILE", "/run/secrets/kafka-password")
+	t.Setenv("REDPANDA_POC_KAFKA_CONSUMER_RETRY_MAX_BACKOFF", "5s")
+
+	config, _, err := loadConfig(configOptions{file: file})
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	if got := config.Kafka.Topics.DefaultProducer; got != "env.output" {
+		t.Errorf("kafka.topics.default-producer = %q, want env.output", got)
+	}
+	if got := config.Server.ShutdownTimeout; got != 45*time.Second {
+		t.Errorf("server.shutdown_timeout = %s, want 45s", got)
+	}
+	if got := config.Kafka.Connection.TLS.ServerName; got != "redpanda.internal" {
+		t.Errorf("kafka.connection.tls.server-name = %q, want redpanda.internal", got)
+	}
+	if got := config.Kafka.Connection.SASL.PasswordFile; got != "/run/secrets/kafka-password" {
+		t.Errorf("kafka.connection.sasl.password-file = %q, want /run/secrets/kafka-password", got)
+	}
+	if got := config.Kafka.Consumer.Retry.MaxBackoff; got != 5*time.Second {
+		t.Errorf("kafka.consumer.retry.max-backoff = %s, want 5s", got)
+	}
+}
+
+func TestAppProduce(t *testing.T) {
+	cluster := newTestCluster(t)
+	server := startTestServer(t, cluster, newRecordCollector().handle)
+
+	request := model.ProduceMessageRequest{Key: "order-1", Message: "created", Headers: map[string]string{"tenant": "acme"}}
+	var produced model.ProduceMessageResponse
+	if status := server.do(t, http.MethodPost, "/produce?sync=true", request, &produced); status != http.StatusOK {
+		t.Fatalf("POST /produce?sync=true returned %d, want %d", status, http.StatusOK)
+	}
+	if produced.Topic != "test.output" {
+		t.Errorf("produced to topic %q, want the default producer topic test.output", produced.Topic)
+	}
+
+	reader := newTestClient(t, cluster, kgo.ConsumePartitions(map[string]map[int32]kgo.Offset{
+		produced.Topic: {produced.Partition: kgo.NewOffset().At(produced.Offset)},
+	}))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	fetches := reader.PollRecords(ctx, 1)
+	if err := fetches.Err0(); err != nil {
+		t.Fatalf("failed to read the produced record: %v", err)
+	}
+	rec := fetches.Records()[0]
+	if string(rec.Key) != "order-1" || string(rec.Value) != "created" {
+		t.Errorf("read record %q=%q, want order-1=created", rec.Key, rec.Value)
+	}
+	if len(rec.Headers) != 1 || rec.Headers[0].Key != "tenant" || string(rec.Headers[0].Value) != "acme" {
+		t.Errorf("read headers %v, want tenant=acme", rec.Headers)
+	}
+
+	if status := server.do(t, http.MethodPost, "/topics/test.audit/produce", request, nil); status != http.StatusForbidden {
+		t.Errorf("producing to a topic outside the allowlist returned %d, want %d", status, http.StatusForbidden)
+	}
+}
+
+func TestAppConsume(t *testing.T) {
+	cluster := newTestCluster(t)
+	collector := newRecordCollector()
+	server := startTestServer(t, cluster, collector.handle)
+
+	request := model.ProduceMessageRequest{Key: "order-2", Message: "paid", Headers: map[string]string{"correlation-id": "42"}}
+	if status := server.do(t, http.MethodPost, "/topics/test.input/produce?sync=true", request, nil); status != http.StatusOK {
+		t.Fatalf("POST /topics/test.input/produce returned %d, want %d", status, http.StatusOK)
+	}
+
+	waitFor(t, "the record to be consumed", func() bool {
+		_, ok := collector.find("order-2")
+		return ok
+	})
+
+	rec, _ := collector.find("order-2")
+	if rec.Topic != "test.input" || string(rec.Value) != "paid" {
+		t.Errorf("consumed %s: %q, want test.input: paid", rec.Topic, rec.Value)
+	}
+	if len(rec.Headers) != 1 || string(rec.Headers[0].Value) != "42" {
+		t.Errorf("consumed headers %v, want correlation-id=42", rec.Headers)
+	}
+}
+
+func TestAppMemberLeavingHandsOverPartitions(t *testing.T) {
+	cluster := newTestCluster(t)
+	collector := newRecordCollector()
+
+	var mu sync.Mutex
+	deliveries := make(map[string]int) // by partition/offset
+	handler := func(ctx context.Context, rec *kgo.Record) error {
+		mu.Lock()
+		deliveries[fmt.Sprintf("%d/%d", rec.Partition, rec.Offset)]++
+		mu.Unlock()
+		return collector.handle(ctx, rec)
+	}
+
+	first := startTestServer(t, cluster, handler)
+	second := startTestServer(t, cluster, handler)
+
+	waitFor(t, "both members to hold partitions", func() bool {
+		return len(first.app.kafka.consumer.Assignments()["test.input"]) > 0 &&
+			len(second.app.kafka.consumer.Assignments()["test.input"]) > 0
+	})
+
+	const records = 60
+	produce := func(from int) {
+		t.Helper()
+
+		batch := model.ProduceBatchRequest{}
+		for i := from; i < from+records; i++ {
+			batch.Records = append(batch.Records, model.BatchRecord{Key: fmt.Sprintf("key-%d", i), Value: "value", Topic: "test.input"})
+		}
+
+		var produced model.ProduceBatchResponse
+		if status := first.do(t, http.MethodPost, "/produce/batch", batch, &produced); status != http.StatusOK {
+			t.Fatalf("POST /produce/batch returned %d, want %d", status, http.StatusOK)
+		}
+		if produced.Succeeded != records {
+			t.Fatalf("batch produced %d records, want %d: %+v", produced.Succeeded, records, produced.Results)
+		}
+	}
+
+	produce(0)
+	waitFor(t, "both members to consume", func() bool { return collector.count() == records })
+
+	// the second member leaves; the first takes over its partitions from the committed offsets
+	second.shutdownKafka(t)
+	waitFor(t, "the remaining member to hold every partition", func() bool {
+		return len(first.app.kafka.consumer.Assignments()["test.input"]) == 3
+	})
+
+	produce(records)
+	waitFor(t, "the remaining member to consume", func() bool { return collector.count() == 2*records })
+
+	mu.Lock()
+	for record, n := range deliveries {
+		if n > 1 {
+			t.Errorf("record %s processed %d times, want once", record, n)
+		}
+	}
+	mu.Unlock()
+
+	// everything processed is committed, so a restart would not consume it again
+	admin := kadm.NewClient(newTestClient(t, cluster))
+	ends, err := admin.ListEndOffsets(context.Background(), "test.input")
+	if err != nil {
+		t.Fatalf("failed to list end offsets: %v", err)
+	}
+	waitFor(t, "the group to commit every processed record", func() bool {
+		committed, err := admin.FetchOffsets(context.Background(), "test.group")
+		if err != nil {
+			return false
+		}
+
+		caughtUp := true
+		ends.Each(func(end kadm.ListedOffset) {
+			if offset, _ := committed.Lookup("test.input", end.Partition); offset.At != end.Offset {
+				caughtUp = false
+			}
+		})
+		return caughtUp
+	})
+}
+
+func TestAppTopicCreation(t *testing.T) {
+	cluster := newTestCluster(t)
+	server := startTestServer(t, cluster, newRecordCollector().handle)
+
+	var audit model.TopicDescription
+	if status := server.do(t, http.MethodGet, "/admin/topics/test.audit", nil, &audit); status != http.StatusOK {
+		t.Fatalf("GET /admin/topics/test.audit returned %d, want %d", status, http.StatusOK)
+	}
+	if audit.Partitions != 2 {
+		t.Errorf("declared topic has %d partitions, want 2", audit.Partitions)
+	}
+	if policy := audit.Configs["cleanup.policy"]; policy != "compact" {
+		t.Errorf("declared topic has cleanup.policy %q, want compact", policy)
+	}
+
+	for _, topic := range []string{"test.input", "test.output", "test.input.dlq"} {
+		if status := server.do(t, http.MethodGet, "/admin/topics/"+topic, nil, nil); status != http.StatusOK {
+			t.Errorf("default topic %s was not created: GET returned %d", topic, status)
+		}
+	}
+
+	request := model.CreateTopicRequest{Name: "test.created", Partitions: 4}
+	if status := server.do(t, http.MethodPost, "/admin/topics", request, nil); status != http.StatusCreated {
+		t.Fatalf("POST /admin/topics returned %d, want %d", status, http.StatusCreated)
+	}
+
+	var created model.TopicDescription
+	if status := server.do(t, http.MethodGet, "/admin/topics/test.created", nil, &created); status != http.StatusOK {
+		t.Fatalf("GET /admin/topics/test.created returned %d, want %d", status, http.StatusOK)
+	}
+	if created.Partitions != 4 {
+		t.Errorf("created topic has %d partitions, want 4", created.Partitions)
+	}
+}
+
+func TestAppAdminAuthorization(t *testing.T) {
+	cluster := newTestCluster(t)
+	server := startTestServer(t, cluster, newRecordCollector().handle)
+
+	// declared and allowlisted topics are protected like the default ones
+	for _, topic := range []string{"test.audit", "test.input", "test.output", "test.input.dlq"} {
+		if status := server.do(t, http.MethodDelete, "/admin/topics/"+topic, nil, nil); status != http.StatusConflict {
+			t.Errorf("DELETE /admin/topics/%s returned %d, want %d", topic, status, http.StatusConflict)
+		}
+	}
+
+	for _, token := range []string{"", "wrong-token"} {
+		server.token = token
+		if status := server.do(t, http.MethodGet, "/admin/config", nil, nil); status != http.StatusUnauthorized {
+			t.Errorf("GET /admin/config with token %q returned %d, want %d", token, status, http.StatusUnauthorized)
+		}
+		if status := server.do(t, http.MethodDelete, "/admin/topics/test.created", nil, nil); status != http.StatusUnauthorized {
+			t.Errorf("DELETE /admin/topics/test.created with token %q returned %d, want %d", token, status, http.StatusUnauthorized)
+		}
+	}
+
+	// the token itself is redacted from the effective configuration
+	server.token = "test-admin-token"
+	var config map[string]any
+	if status := server.do(t, http.MethodGet, "/admin/config", nil, &config); status != http.StatusOK {
+		t.Fatalf("GET /admin/config returned %d, want %d", status, http.StatusOK)
+	}
+	if token := config["server"].(map[string]any)["admin"].(map[string]any)["token"]; token != redactedValue {
+		t.Errorf("effective configuration shows the admin token as %v, want it redacted", token)
+	}
+}
+
+// readCommitted reads the committed records of a topic from the start until want records
+// arrived, then keeps reading briefly so that unexpected extra records are returned too
+func readCommitted(t *testing.T, cluster *kfake.Cluster, topic string, want int) []*kgo.Record {
+	t.Helper()
+
+	reader := newTestClient(t, cluster,
+		kgo.ConsumeTopics(topic),
+		kgo.ConsumeResetOffset(kgo.NewOffset().AtStart()),
+		kgo.FetchIsolationLevel(kgo.ReadCommitted()),
+	)
+
+	var records []*kgo.Record
+	poll := func(timeout time.Duration) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		records = append(records, reader.PollFetches(ctx).Records()...)
+	}
+
+	deadline := time.Now().Add(30 * time.Second)
+	for len(records) < want && time.Now().Before(deadline) {
+		poll(time.Second)
+	}
+	poll(500 * time.Millisecond)
+
+	return records
+}
+
+// requireTransactions skips the test when the fake cluster does not support transactions.
+// kfake only implements them in versions that require a newer Go and franz-go than the ones
+// used here, so with the pinned kfake the test always skips.
+func requireTransactions(t *testing.T, cluster *kfake.Cluster) {
+	t.Helper()
+
+	probe := newTestClient(t, cluster, kgo.TransactionalID("test.probe"))
+	if err := probe.BeginTransaction(); err != nil {
+		t.Skipf("fake cluster does not support transactions, the transactional mode has no integration coverage: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := probe.EndTransaction(ctx, kgo.TryAbort); err != nil {
+		t.Fatalf("failed to abort the probe transaction: %v", err)
+	}
+}
+
+// TestAppTransactional covers the exactly-once path end to end: committed derived records,
+// re-handling after an aborted transaction and dead-lettering within a transaction. It needs a
+// fake cluster implementing transactions and is skipped until kfake and franz-go are upgraded;
+// until then none of this runs against a broker, kafka_transactions_test.go only covers the
+// bookkeeping of the transactional consumer.
+func TestAppTransactional(t *testing.T) {
+	cluster := newTestCluster(t)
+	requireTransactions(t, cluster)
+
+	var mu sync.Mutex
+	attempts := make(map[string]int)
+	server := startTransactionalTestServer(t, cluster, func(ctx context.Context, rec *kgo.Record) ([]*kgo.Record, error) {
+		mu.Lock()
+		attempts[string(rec.Key)]++
+		attempt := attempts[string(rec.Key)]
+		mu.Unlock()
+
+		switch {
+		case string(rec.Key) == "poison":
+			return nil, errors.New("poison record")
+		case string(rec.Key) == "flaky" && attempt == 1:
+			return nil, errors.New("first attempt fails")
+		}
+		return []*kgo.Record{{Key: rec.Key, Value: []byte(strings.ToUpper(string(rec.Value)))}}, nil
+	})
+	server.app.kafka.deadLetter.setRetryPolicy(config_models.RetryPolicy{MaxRetries: 1})
+
+	for _, key := range []string{"order-3", "flaky", "poison"} {
+		request := model.ProduceMessageRequest{Key: key, Message: "shipped"}
+		if status := server.do(t, http.MethodPost, "/topics/test.input/produce?sync=true", request, nil); status != http.StatusOK {
+			t.Fatalf("POST /topics/test.input/produce returned %d, want %d", status, http.StatusOK)
+		}
+	}
+
+	// the aborted transaction of the flaky record's first attempt is invisible, so every
+	// successfully transformed record is read exactly once
+	derived := make(map[string]int)
+	for _, rec := range readCommitted(t, cluster, "test.output", 2) {
+		if string(rec.Value) != "SHIPPED" {
+			t.Errorf("derived record %s has value %q, want SHIPPED", rec.Key, rec.Value)
+		}
+		derived[string(rec.Key)]++
+	}
+	if derived["order-3"] != 1 || derived["flaky"] != 1 || len(derived) != 2 {
+		t.Errorf("read derived records %v, want order-3 and flaky exactly once", derived)
+	}
+
+	// records sharing a batch with a failing one are handled again after the abort
+	mu.Lock()
+	if attempts["flaky"] < 2 {
+		t.Errorf("flaky record handled %d times, want at least 2", attempts["flaky"])
+	}
+	if attempts["poison"] != 2 {
+		t.Errorf("poison record handled %d times, want 2 before being dead-lettered", attempts["poison"])
+	}
+	mu.Unlock()
+
+	dead := readCommitted(t, cluster, "test.input.dlq", 1)
+	if len(dead) != 1 || string(dead[0].Key) != "poison" {
+		t.Errorf("read %d dead-lettered records, want only the poison record", len(dead))
+	}
+
+	if assigned := len(server.app.kafka.transact.Assignments()["test.input"]); assigned != 3 {
+		t.Errorf("transactional consumer holds %d partitions of test.input, want 3", assigned)
+	}
+}
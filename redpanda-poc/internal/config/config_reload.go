@@ -0,0 +1,200 @@
+package config
+
+import (
+	"log/slog"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/geo-gkez/go-pocs/redpanda-poc/internal/config/models"
+	"github.com/spf13/viper"
+)
+
+// redactedValue replaces the value of configuration fields tagged secret:"true"
+const redactedValue = "******"
+
+// reloadableKeys are the configuration keys applied at runtime; changing any other key
+// requires a restart
+var reloadableKeys = []string{
+	"server.log_level",
+	"server.rate_limit",
+	"kafka.producer",
+	"kafka.consumer.retry",
+}
+
+// liveConfig holds the effective configuration and applies the reloadable part of it
+// whenever the configuration files change
+type liveConfig struct {
+	mu      sync.Mutex
+	current *config_models.AppConfiguration
+	apply   func(config *config_models.AppConfiguration)
+}
+
+// newLiveConfig applies the initial configuration and returns the holder of it
+func newLiveConfig(config *config_models.AppConfiguration, apply func(config *config_models.AppConfiguration)) *liveConfig {
+	apply(config)
+	return &liveConfig{current: config, apply: apply}
+}
+
+// Redacted returns the effective configuration keyed like the configuration files,
+// with secrets redacted
+func (c *liveConfig) Redacted() map[string]any {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return settingsMap(reflect.ValueOf(*c.current), true)
+}
+
+// watch reloads the configuration whenever one of the given files is written
+func (c *liveConfig) watch(options configOptions, files []string) {
+	// read the same base file again instead of searching the config paths
+	options.file = files[0]
+
+	for _, file := range files {
+		watcher := viper.New()
+		watcher.SetConfigFile(file)
+		watcher.OnConfigChange(func(event fsnotify.Event) {
+			slog.Info("configuration file changed", "file", event.Name)
+			c.reload(options)
+		})
+		watcher.WatchConfig()
+	}
+}
+
+// reload loads and validates the configuration again, then applies the reloadable keys.
+// Changes of any other key are logged and ignored, and an invalid configuration is
+// ignored as a whole
+func (c *liveConfig) reload(options configOptions) {
+	updated, _, err := loadConfig(options)
+	if err != nil {
+		slog.Error("ignoring configuration change", "error", err)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if ignored := structuralChanges(c.current, updated); len(ignored) > 0 {
+		slog.Warn("configuration changes require a restart and were ignored", "keys", ignored)
+	}
+
+	effective := *c.current
+	effective.Server.LogLevel = updated.Server.LogLevel
+	effective.Server.RateLimit = updated.Server.RateLimit
+	effective.Kafka.Producer = updated.Kafka.Producer
+	effective.Kafka.Consumer.Retry = updated.Kafka.Consumer.Retry
+
+	c.current = &effective
+	c.apply(&effective)
+
+	slog.Info("configuration reloaded")
+}
+
+// structuralChanges lists the keys that differ between both configurations and cannot
+// be applied at runtime
+func structuralChanges(current, updated *config_models.AppConfiguration) []string {
+	changed := changedKeys("",
+		settingsMap(reflect.ValueOf(*current), false),
+		settingsMap(reflect.ValueOf(*updated), false))
+
+	return slices.DeleteFunc(changed, func(key string) bool {
+		return slices.ContainsFunc(reloadableKeys, func(reloadable string) bool {
+			return key == reloadable || strings.HasPrefix(key, reloadable+".")
+		})
+	})
+}
+
+// changedKeys returns the dotted keys whose values differ between both settings maps
+func changedKeys(prefix string, current, updated map[string]any) []string {
+	keys := make(map[string]struct{}, len(current))
+	for key := range current {
+		keys[key] = struct{}{}
+	}
+	for key := range updated {
+		keys[key] = struct{}{}
+	}
+
+	var changed []string
+	for key := range keys {
+		currentSection, currentIsMap := current[key].(map[string]any)
+		updatedSection, updatedIsMap := updated[key].(map[string]any)
+
+		switch {
+		case currentIsMap && updatedIsMap:
+			changed = append(changed, changedKeys(prefix+key+".", currentSection, updatedSection)...)
+		case !reflect.DeepEqual(current[key], updated[key]):
+			changed = append(changed, prefix+key)
+		}
+	}
+
+	sort.Strings(changed)
+	return changed
+}
+
+// settingKey returns the key of a configuration struct field in the configuration files
+func settingKey(field reflect.StructField) string {
+	if name, _, _ := strings.Cut(field.Tag.Get("mapstructure"), ","); name != "" {
+		return name
+	}
+	return strings.ToLower(field.Name)
+}
+
+// settingKeys returns the dotted keys of every setting of a configuration struct, e.g.
+// kafka.topics.default-producer. Lists of structs such as kafka.topics.definitions are
+// returned as a single key.
+func settingKeys(typ reflect.Type, prefix string) []string {
+	var keys []string
+
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		key := prefix + settingKey(field)
+
+		if field.Type.Kind() == reflect.Struct {
+			keys = append(keys, settingKeys(field.Type, key+".")...)
+			continue
+		}
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+// settingsMap converts a configuration struct into a map keyed like the configuration
+// files, rendering durations as strings and optionally redacting fields tagged secret:"true"
+func settingsMap(value reflect.Value, redact bool) map[string]any {
+	settings := make(map[string]any, value.NumField())
+
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		fieldValue := value.Field(i)
+
+		key := settingKey(field)
+
+		switch {
+		case redact && field.Tag.Get("secret") == "true":
+			if !fieldValue.IsZero() {
+				settings[key] = redactedValue
+			} else {
+				settings[key] = ""
+			}
+		case field.Type == reflect.TypeOf(time.Duration(0)):
+			settings[key] = time.Duration(fieldValue.Int()).String()
+		case field.Type.Kind() == reflect.Struct:
+			settings[key] = settingsMap(fieldValue, redact)
+		case field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() == reflect.Struct:
+			items := make([]map[string]any, fieldValue.Len())
+			for j := range items {
+				items[j] = settingsMap(fieldValue.Index(j), redact)
+			}
+			settings[key] = items
+		default:
+			settings[key] = fieldValue.Interface()
+		}
+	}
+
+	return settings
+}
@@ -0,0 +1,109 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/geo-gkez/go-pocs/redpanda-poc/internal/config/models"
+)
+
+func TestStructuralChanges(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(config *config_models.AppConfiguration)
+		want   []string
+	}{
+		{
+			name:   "unchanged",
+			modify: func(config *config_models.AppConfiguration) {},
+		},
+		{
+			name: "reloadable keys only",
+			modify: func(config *config_models.AppConfiguration) {
+				config.Server.LogLevel = "debug"
+				config.Server.RateLimit.RequestsPerSecond = 10
+				config.Kafka.Producer.Timeout = time.Second
+				config.Kafka.Consumer.Retry.MaxRetries = 5
+			},
+		},
+		{
+			name: "structural keys",
+			modify: func(config *config_models.AppConfiguration) {
+				config.Server.Port = 9090
+				config.Kafka.Connection.Brokers = []string{"redpanda-0:9092"}
+				config.Kafka.Consumer.Workers = 4
+				config.Kafka.Consumer.Retry.MaxRetries = 5
+			},
+			want: []string{"kafka.connection.brokers", "kafka.consumer.workers", "server.port"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			current := validTestConfig()
+			updated := validTestConfig()
+			test.modify(updated)
+
+			if got := structuralChanges(current, updated); !slices.Equal(got, test.want) {
+				t.Errorf("structuralChanges() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestLiveConfigReload(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yml")
+	write := func(logLevel, group string, maxRetries int) {
+		t.Helper()
+		content := fmt.Sprintf(testConfigFile, "localhost:19092")
+		content = strings.Replace(content, "log_level: warn", "log_level: "+logLevel, 1)
+		content = strings.Replace(content, "default-consumer-group: test.group", "default-consumer-group: "+group, 1)
+		content = strings.Replace(content, "max-retries: 0", fmt.Sprintf("max-retries: %d", maxRetries), 1)
+		if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+			t.Fatalf("failed to write config file: %v", err)
+		}
+	}
+
+	write("warn", "test.group", 0)
+	options := configOptions{file: file}
+	config, _, err := loadConfig(options)
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	var applied []*config_models.AppConfiguration
+	live := newLiveConfig(config, func(config *config_models.AppConfiguration) {
+		applied = append(applied, config)
+	})
+
+	// a reloadable and a structural key change together
+	write("debug", "other.group", 3)
+	live.reload(options)
+
+	if len(applied) != 2 {
+		t.Fatalf("apply called %d times, want 2 (initial and reload)", len(applied))
+	}
+	reloaded := applied[1]
+	if reloaded.Server.LogLevel != "debug" || reloaded.Kafka.Consumer.Retry.MaxRetries != 3 {
+		t.Errorf("reloadable keys not applied: log level %q, max retries %d", reloaded.Server.LogLevel, reloaded.Kafka.Consumer.Retry.MaxRetries)
+	}
+	if reloaded.Kafka.Topics.DefaultConsumerGroup != "test.group" {
+		t.Errorf("structural key applied: consumer group %q, want test.group", reloaded.Kafka.Topics.DefaultConsumerGroup)
+	}
+	if group := live.Redacted()["kafka"].(map[string]any)["topics"].(map[string]any)["default-consumer-group"]; group != "test.group" {
+		t.Errorf("effective consumer group %v, want test.group", group)
+	}
+
+	// an invalid configuration is ignored as a whole
+	write("verbose", "test.group", 5)
+	live.reload(options)
+
+	if len(applied) != 2 {
+		t.Errorf("invalid configuration applied: apply called %d times, want 2", len(applied))
+	}
+}
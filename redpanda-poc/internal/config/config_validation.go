@@ -0,0 +1,350 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/geo-gkez/go-pocs/redpanda-poc/internal/config/logger"
+	"github.com/geo-gkez/go-pocs/redpanda-poc/internal/config/models"
+	"github.com/gin-gonic/gin"
+)
+
+// maxTopicNameLength is the longest topic name Kafka accepts
+const maxTopicNameLength = 249
+
+// legalTopicName matches the characters Kafka allows in topic names
+var legalTopicName = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
+
+// validateConfig checks the whole configuration before anything connects and reports
+// every problem found at once, one per line
+func validateConfig(config *config_models.AppConfiguration) error {
+	var errs []error
+	errs = append(errs, validateServer(config.Server)...)
+	errs = append(errs, validateKafka(config.Kafka)...)
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration:\n%w", errors.Join(errs...))
+	}
+	return nil
+}
+
+func validateServer(server config_models.ServerConfiguration) []error {
+	var errs []error
+
+	if server.Port < 1 || server.Port > 65535 {
+		errs = append(errs, fmt.Errorf("server.port: %d is not a valid port, must be between 1 and 65535", server.Port))
+	}
+
+	switch server.Mode {
+	case "", gin.DebugMode, gin.ReleaseMode, gin.TestMode:
+	default:
+		errs = append(errs, fmt.Errorf("server.mode: unknown mode %q, must be one of %s, %s or %s",
+			server.Mode, gin.DebugMode, gin.ReleaseMode, gin.TestMode))
+	}
+
+	if _, ok := logger.ParseLevel(server.LogLevel); !ok {
+		errs = append(errs, fmt.Errorf("server.log_level: unknown level %q, must be one of debug, info, warn or error", server.LogLevel))
+	}
+
+	if server.ShutdownTimeout < 0 {
+		errs = append(errs, fmt.Errorf("server.shutdown_timeout: %s must not be negative", server.ShutdownTimeout))
+	}
+
+	if server.RateLimit.RequestsPerSecond < 0 {
+		errs = append(errs, fmt.Errorf("server.rate_limit.requests_per_second: %g must not be negative", server.RateLimit.RequestsPerSecond))
+	}
+
+	if server.RateLimit.Burst < 0 {
+		errs = append(errs, fmt.Errorf("server.rate_limit.burst: %d must not be negative", server.RateLimit.Burst))
+	}
+
+	if server.Tail.MaxConcurrent < 0 {
+		errs = append(errs, fmt.Errorf("server.tail.max_concurrent: %d must not be negative", server.Tail.MaxConcurrent))
+	}
+
+	if server.Admin.Enabled && server.Admin.Token == "" {
+		errs = append(errs, errors.New("server.admin.token: required when the admin API is enabled"))
+	}
+
+	if server.Readiness.ProduceBufferThreshold < 0 {
+		errs = append(errs, fmt.Errorf("server.readiness.produce_buffer_threshold: %d must not be negative",
+			server.Readiness.ProduceBufferThreshold))
+	}
+
+	return errs
+}
+
+func validateKafka(kafka config_models.KafkaProperties) []error {
+	var errs []error
+
+	if len(kafka.Connection.Brokers) == 0 {
+		errs = append(errs, errors.New("kafka.connection.brokers: at least one broker is required"))
+	}
+	for i, broker := range kafka.Connection.Brokers {
+		if err := validateBrokerAddress(broker); err != nil {
+			errs = append(errs, fmt.Errorf("kafka.connection.brokers[%d]: %w", i, err))
+		}
+	}
+
+	errs = append(errs, validateTLS(kafka.Connection.TLS)...)
+	errs = append(errs, validateSASL(kafka.Connection.SASL)...)
+	errs = append(errs, validateTopics(kafka.Topics)...)
+
+	if kafka.Producer.Timeout < 0 {
+		errs = append(errs, fmt.Errorf("kafka.producer.timeout: %s must not be negative", kafka.Producer.Timeout))
+	}
+
+	retry := kafka.Consumer.Retry
+	if retry.MaxRetries < 0 {
+		errs = append(errs, fmt.Errorf("kafka.consumer.retry.max-retries: %d must not be negative", retry.MaxRetries))
+	}
+	if retry.InitialBackoff < 0 {
+		errs = append(errs, fmt.Errorf("kafka.consumer.retry.initial-backoff: %s must not be negative", retry.InitialBackoff))
+	}
+	if retry.MaxBackoff < 0 {
+		errs = append(errs, fmt.Errorf("kafka.consumer.retry.max-backoff: %s must not be negative", retry.MaxBackoff))
+	}
+	if retry.MaxBackoff > 0 && retry.MaxBackoff < retry.InitialBackoff {
+		errs = append(errs, fmt.Errorf("kafka.consumer.retry.max-backoff: %s must not be lower than initial-backoff %s",
+			retry.MaxBackoff, retry.InitialBackoff))
+	}
+
+	if kafka.Consumer.Workers < 0 {
+		errs = append(errs, fmt.Errorf("kafka.consumer.workers: %d must not be negative", kafka.Consumer.Workers))
+	}
+
+	if kafka.Consumer.BufferDepth < 0 {
+		errs = append(errs, fmt.Errorf("kafka.consumer.buffer-depth: %d must not be negative", kafka.Consumer.BufferDepth))
+	}
+
+	errs = append(errs, validateMiddleware(kafka.Consumer)...)
+	errs = append(errs, validateTransactions(kafka.Transactions)...)
+
+	return errs
+}
+
+// validateTransactions checks that the transactional mode has a transactional ID
+func validateTransactions(transactions config_models.KafkaTransactions) []error {
+	if transactions.Enabled && transactions.TransactionalID == "" {
+		return []error{errors.New("kafka.transactions.transactional-id: required when transactions are enabled")}
+	}
+	return nil
+}
+
+// validateMiddleware checks that the consumer middlewares are known, listed once, and
+// that the timeout middleware has a timeout to apply
+func validateMiddleware(consumer config_models.KafkaConsumer) []error {
+	var errs []error
+
+	if consumer.HandlerTimeout < 0 {
+		errs = append(errs, fmt.Errorf("kafka.consumer.handler-timeout: %s must not be negative", consumer.HandlerTimeout))
+	}
+
+	seen := make(map[string]bool, len(consumer.Middleware))
+	for i, name := range consumer.Middleware {
+		switch {
+		case !slices.Contains(builtinMiddlewares, name):
+			errs = append(errs, fmt.Errorf("kafka.consumer.middleware[%d]: unknown middleware %q, must be one of %s",
+				i, name, strings.Join(builtinMiddlewares, ", ")))
+		case seen[name]:
+			errs = append(errs, fmt.Errorf("kafka.consumer.middleware[%d]: %q is listed more than once", i, name))
+		case name == middlewareTimeout && consumer.HandlerTimeout == 0:
+			errs = append(errs, fmt.Errorf("kafka.consumer.middleware[%d]: timeout requires kafka.consumer.handler-timeout to be set", i))
+		}
+		seen[name] = true
+	}
+
+	return errs
+}
+
+// validateBrokerAddress checks that a broker is given in host:port form
+func validateBrokerAddress(broker string) error {
+	host, port, err := net.SplitHostPort(broker)
+	if err != nil {
+		return fmt.Errorf("%q is not in host:port form: %w", broker, err)
+	}
+	if host == "" {
+		return fmt.Errorf("%q has no host", broker)
+	}
+	if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+		return fmt.Errorf("%q has an invalid port %q", broker, port)
+	}
+	return nil
+}
+
+func validateTLS(config config_models.KafkaTLS) []error {
+	var errs []error
+
+	if !config.Enabled {
+		return nil
+	}
+
+	if (config.CertFile == "") != (config.KeyFile == "") {
+		errs = append(errs, errors.New("kafka.connection.tls: cert-file and key-file must be set together"))
+	}
+
+	for _, file := range []struct {
+		key  string
+		path string
+	}{
+		{"kafka.connection.tls.ca-file", config.CAFile},
+		{"kafka.connection.tls.cert-file", config.CertFile},
+		{"kafka.connection.tls.key-file", config.KeyFile},
+	} {
+		if err := validateReadableFile(file.path); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", file.key, err))
+		}
+	}
+
+	return errs
+}
+
+func validateSASL(config config_models.KafkaSASL) []error {
+	var errs []error
+
+	switch strings.ToUpper(config.Mechanism) {
+	case "":
+		return nil
+	case saslPlain, saslScramSHA256, saslScramSHA512:
+		if config.Username == "" {
+			errs = append(errs, fmt.Errorf("kafka.connection.sasl.username: required by the %s mechanism", config.Mechanism))
+		}
+		switch {
+		case config.Password == "" && config.PasswordFile == "":
+			errs = append(errs, fmt.Errorf("kafka.connection.sasl: password or password-file is required by the %s mechanism", config.Mechanism))
+		case config.Password != "" && config.PasswordFile != "":
+			errs = append(errs, errors.New("kafka.connection.sasl: only one of password and password-file may be set"))
+		}
+		if err := validateReadableFile(config.PasswordFile); err != nil {
+			errs = append(errs, fmt.Errorf("kafka.connection.sasl.password-file: %w", err))
+		}
+	case saslOAuthBearer:
+		switch {
+		case config.Token == "" && config.TokenFile == "":
+			errs = append(errs, fmt.Errorf("kafka.connection.sasl: token or token-file is required by the %s mechanism", config.Mechanism))
+		case config.Token != "" && config.TokenFile != "":
+			errs = append(errs, errors.New("kafka.connection.sasl: only one of token and token-file may be set"))
+		}
+		if err := validateReadableFile(config.TokenFile); err != nil {
+			errs = append(errs, fmt.Errorf("kafka.connection.sasl.token-file: %w", err))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("kafka.connection.sasl.mechanism: unknown mechanism %q, must be one of %s, %s, %s or %s",
+			config.Mechanism, saslPlain, saslScramSHA256, saslScramSHA512, saslOAuthBearer))
+	}
+
+	return errs
+}
+
+// validateReadableFile checks that an optional file setting points to a readable file
+func validateReadableFile(path string) error {
+	if path == "" {
+		return nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+func validateTopics(topics config_models.KafkaTopics) []error {
+	var errs []error
+
+	fields := []struct {
+		key      string
+		value    string
+		required bool
+		topic    bool
+	}{
+		{"kafka.topics.default-producer", topics.DefaultProducer, true, true},
+		{"kafka.topics.default-consumer", topics.DefaultConsumer, true, true},
+		{"kafka.topics.default-consumer-group", topics.DefaultConsumerGroup, true, false},
+		{"kafka.topics.dead-letter", topics.DeadLetter, false, true},
+	}
+	for _, field := range fields {
+		switch {
+		case field.value == "":
+			if field.required {
+				errs = append(errs, fmt.Errorf("%s: must not be empty", field.key))
+			}
+		case field.topic:
+			if err := validateTopicName(field.value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", field.key, err))
+			}
+		}
+	}
+
+	seen := make(map[string]bool, len(topics.AllowedProducerTopics))
+	for i, topic := range topics.AllowedProducerTopics {
+		key := fmt.Sprintf("kafka.topics.allowed-producer-topics[%d]", i)
+		if err := validateTopicName(topic); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+		}
+		if seen[topic] {
+			errs = append(errs, fmt.Errorf("%s: topic %q is listed more than once", key, topic))
+		}
+		seen[topic] = true
+	}
+
+	declared := make(map[string]bool, len(topics.Definitions))
+	for i, definition := range topics.Definitions {
+		key := fmt.Sprintf("kafka.topics.definitions[%d]", i)
+		if err := validateTopicName(definition.Name); err != nil {
+			errs = append(errs, fmt.Errorf("%s.name: %w", key, err))
+		}
+		if declared[definition.Name] {
+			errs = append(errs, fmt.Errorf("%s.name: topic %q is declared more than once", key, definition.Name))
+		}
+		declared[definition.Name] = true
+
+		if definition.Partitions < 0 {
+			errs = append(errs, fmt.Errorf("%s.partitions: %d must not be negative", key, definition.Partitions))
+		}
+		if definition.ReplicationFactor < 0 {
+			errs = append(errs, fmt.Errorf("%s.replication-factor: %d must not be negative", key, definition.ReplicationFactor))
+		}
+	}
+
+	// A consumer producing into the topic it consumes would process its own output again
+	if topics.DefaultProducer != "" && topics.DefaultProducer == topics.DefaultConsumer {
+		errs = append(errs, fmt.Errorf("kafka.topics.default-producer: %q must differ from default-consumer", topics.DefaultProducer))
+	}
+
+	// Dead-lettering into a topic that is consumed or produced to would mix failed
+	// records with regular traffic, or feed them back into the handler
+	if dlq := topics.DeadLetter; dlq != "" {
+		if dlq == topics.DefaultConsumer {
+			errs = append(errs, fmt.Errorf("kafka.topics.dead-letter: %q must differ from default-consumer", dlq))
+		}
+		if dlq == topics.DefaultProducer {
+			errs = append(errs, fmt.Errorf("kafka.topics.dead-letter: %q must differ from default-producer", dlq))
+		}
+		if seen[dlq] {
+			errs = append(errs, fmt.Errorf("kafka.topics.dead-letter: %q must not be one of allowed-producer-topics", dlq))
+		}
+	}
+
+	return errs
+}
+
+// validateTopicName applies the topic naming rules of Kafka
+func validateTopicName(topic string) error {
+	switch {
+	case topic == "":
+		return errors.New("topic name must not be empty")
+	case topic == "." || topic == "..":
+		return fmt.Errorf("%q is not a valid topic name", topic)
+	case len(topic) > maxTopicNameLength:
+		return fmt.Errorf("topic name %q is longer than %d characters", topic, maxTopicNameLength)
+	case !legalTopicName.MatchString(topic):
+		return fmt.Errorf("topic name %q may only contain letters, digits, '.', '_' and '-'", topic)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/geo-gkez/go-pocs/redpanda-poc/internal/config/models"
+)
+
+// validTestConfig returns a configuration that passes validation
+func validTestConfig() *config_models.AppConfiguration {
+	config := &config_models.AppConfiguration{}
+	config.Server.Port = 8085
+	config.Server.LogLevel = "info"
+	config.Kafka.Connection.Brokers = []string{"localhost:19092"}
+	config.Kafka.Topics.DefaultProducer = "test.output"
+	config.Kafka.Topics.DefaultConsumer = "test.input"
+	config.Kafka.Topics.DefaultConsumerGroup = "test.group"
+	config.Kafka.Topics.DeadLetter = "test.input.dlq"
+	return config
+}
+
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(config *config_models.AppConfiguration)
+		want   []string // one reported line per entry, empty for a valid configuration
+	}{
+		{
+			name:   "valid",
+			modify: func(config *config_models.AppConfiguration) {},
+		},
+		{
+			name: "producer consuming its own output",
+			modify: func(config *config_models.AppConfiguration) {
+				config.Kafka.Topics.DefaultProducer = "test.input"
+			},
+			want: []string{`kafka.topics.default-producer: "test.input" must differ from default-consumer`},
+		},
+		{
+			name: "dead-letter topic consumed",
+			modify: func(config *config_models.AppConfiguration) {
+				config.Kafka.Topics.DeadLetter = "test.input"
+			},
+			want: []string{`kafka.topics.dead-letter: "test.input" must differ from default-consumer`},
+		},
+		{
+			name: "transactions without a transactional ID",
+			modify: func(config *config_models.AppConfiguration) {
+				config.Kafka.Transactions.Enabled = true
+			},
+			want: []string{"kafka.transactions.transactional-id: required when transactions are enabled"},
+		},
+		{
+			name: "admin API without a token",
+			modify: func(config *config_models.AppConfiguration) {
+				config.Server.Admin.Enabled = true
+			},
+			want: []string{"server.admin.token: required when the admin API is enabled"},
+		},
+		{
+			name: "timeout middleware without a handler timeout",
+			modify: func(config *config_models.AppConfiguration) {
+				config.Kafka.Consumer.Middleware = []string{"timeout"}
+			},
+			want: []string{"kafka.consumer.middleware[0]: timeout requires kafka.consumer.handler-timeout to be set"},
+		},
+		{
+			name: "every problem is reported",
+			modify: func(config *config_models.AppConfiguration) {
+				config.Server.Port = 0
+				config.Server.LogLevel = "verbose"
+				config.Kafka.Connection.Brokers = []string{"localhost"}
+				config.Kafka.Topics.DefaultConsumerGroup = ""
+				config.Kafka.Topics.DefaultProducer = "test.input"
+				config.Kafka.Consumer.Retry.MaxRetries = -1
+				config.Kafka.Consumer.HandlerTimeout = -time.Second
+			},
+			want: []string{
+				"server.port: 0 is not a valid port",
+				`server.log_level: unknown level "verbose"`,
+				`kafka.connection.brokers[0]: "localhost" is not in host:port form`,
+				"kafka.topics.default-consumer-group: must not be empty",
+				`kafka.topics.default-producer: "test.input" must differ from default-consumer`,
+				"kafka.consumer.retry.max-retries: -1 must not be negative",
+				"kafka.consumer.handler-timeout: -1s must not be negative",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := validTestConfig()
+			test.modify(config)
+
+			err := validateConfig(config)
+			if len(test.want) == 0 {
+				if err != nil {
+					t.Fatalf("validateConfig() = %v, want no error", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("validateConfig() = nil, want an error")
+			}
+
+			lines := strings.Split(err.Error(), "\n")[1:] // after "invalid configuration:"
+			if len(lines) != len(test.want) {
+				t.Errorf("validateConfig() reported %d problems, want %d:\n%v", len(lines), len(test.want), err)
+			}
+			for _, want := range test.want {
+				found := false
+				for _, line := range lines {
+					found = found || strings.HasPrefix(line, want)
+				}
+				if !found {
+					t.Errorf("validateConfig() did not report %q:\n%v", want, err)
+				}
+			}
+		})
+	}
+}
@@ -1,206 +1,730 @@
 package config
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
-	"strings"
+	"log/slog"
+	"slices"
 	"sync"
 	"time"
 
+	"github.com/geo-gkez/go-pocs/redpanda-poc/internal/config/logger"
 	"github.com/geo-gkez/go-pocs/redpanda-poc/internal/config/models"
+	"github.com/geo-gkez/go-pocs/redpanda-poc/internal/metrics"
+	"github.com/geo-gkez/go-pocs/redpanda-poc/internal/service"
 	"github.com/twmb/franz-go/pkg/kadm"
 	"github.com/twmb/franz-go/pkg/kgo"
 )
 
+// commitTimeout bounds a single synchronous offset commit
+const commitTimeout = 10 * time.Second
+
+// defaultBufferDepth is used when kafka.consumer.buffer-depth is not configured
+const defaultBufferDepth = 10
+
 type pconsumer struct {
+	topic     string
+	partition int32
+
 	quit chan struct{}
+	done chan struct{} // closed once the consume goroutine has returned
 	recs chan []*kgo.Record
+
+	// pending is the last record whose handler completed but whose offset is not committed yet.
+	// It is owned by the consume goroutine and only read by others after done is closed.
+	pending *kgo.Record
+
+	mu     sync.Mutex      // guards parked and paused
+	parked [][]*kgo.Record // fetched batches that did not fit into recs, in fetch order
+	paused bool            // fetching of the partition is paused until parked is drained
+}
+
+// deliver hands a fetched batch to the partition consumer without blocking. When its buffer
+// is full the batch is parked and fetching of the partition is paused until the consumer
+// caught up, so a slow partition does not hold up the others. Batches for a consumer that
+// is quitting are dropped; their offsets are not committed, so they are fetched again.
+func (pc *pconsumer) deliver(cl *kgo.Client, recs []*kgo.Record) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	select {
+	case <-pc.quit:
+		return
+	default:
+	}
+
+	if len(pc.parked) == 0 {
+		select {
+		case pc.recs <- recs:
+			return
+		default:
+		}
+	}
+
+	if !pc.paused {
+		cl.PauseFetchPartitions(map[string][]int32{pc.topic: {pc.partition}})
+		pc.paused = true
+	}
+	pc.parked = append(pc.parked, recs)
+}
+
+// unpark moves parked batches into the buffer as far as it has room, and resumes fetching
+// of the partition once every parked batch is buffered
+func (pc *pconsumer) unpark(cl *kgo.Client) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	for len(pc.parked) > 0 {
+		select {
+		case pc.recs <- pc.parked[0]:
+			pc.parked[0] = nil
+			pc.parked = pc.parked[1:]
+		default:
+			return
+		}
+	}
+
+	if pc.paused {
+		cl.ResumeFetchPartitions(map[string][]int32{pc.topic: {pc.partition}})
+		pc.paused = false
+	}
+}
+
+// release drops the parked batches of a stopped partition consumer and resumes fetching of
+// the partition, so it is not left paused when the partition is assigned again
+func (pc *pconsumer) release(cl *kgo.Client) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	pc.parked = nil
+	if pc.paused {
+		cl.ResumeFetchPartitions(map[string][]int32{pc.topic: {pc.partition}})
+		pc.paused = false
+	}
 }
 
-func (pc *pconsumer) consume(topic string, partition int32, handler MessageHandler) {
-	fmt.Printf("starting, t %s p %d\n", topic, partition)
+// backlog reports the batches and records waiting for the partition consumer
+func (pc *pconsumer) backlog() metrics.PartitionBacklog {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	backlog := metrics.PartitionBacklog{Batches: len(pc.recs) + len(pc.parked), Paused: pc.paused}
+	for _, recs := range pc.parked {
+		backlog.Records += len(recs)
+	}
+	return backlog
+}
+
+func (pc *pconsumer) consume(cl *kgo.Client, log *slog.Logger, route *handlerRoute, handler MessageHandler, dlq *deadLetterQueue) {
+	log.Info("starting partition consumer")
+	// Signal that this partition is no longer being processed
+	defer close(pc.done)
 	// Log when the function exits (stops consuming from this partition)
-	defer fmt.Printf("killing, t %s p %d\n", topic, partition)
+	defer log.Info("partition consumer stopped")
 
 	// Main processing loop
 	for {
+		// Check quit first: select picks randomly among ready cases, so a buffered batch
+		// could otherwise still be taken after quit closed
+		select {
+		case <-pc.quit:
+			log.Info("quitting partition consumer")
+			return
+		default:
+		}
+
 		select {
 		// Channel to signal this consumer to quit
 		case <-pc.quit:
-			fmt.Printf("quitting, t %s p %d\n", topic, partition)
+			log.Info("quitting partition consumer")
 			return
 
 		// Channel to receive batches of records for this partition
 		case recs := <-pc.recs:
+			// Refill the buffer from parked batches, resuming fetching once they are all in
+			pc.unpark(cl)
 
 			// Process each record in the batch
 			for _, rec := range recs {
 
-				// handle the record
-				if handler != nil {
-					if err := handler(rec.Key, rec.Value); err != nil {
-						fmt.Printf("Error handling message: %v\n", err)
-					}
+				// wait for the route's concurrency limit, then handle the record,
+				// retrying and dead-lettering it on failure
+				if !route.acquire(pc.quit) {
+					log.Info("quitting partition consumer in the middle of a batch", "offset", rec.Offset)
+					return
+				}
+				processed := dlq.process(context.Background(), cl, log, rec, handler, pc.quit)
+				route.release()
+
+				if !processed {
+					log.Info("quitting partition consumer in the middle of a batch", "offset", rec.Offset)
+					return
 				}
 
+				// the handler completed (or the record was dead-lettered), so its offset may be committed
+				pc.pending = rec
+			}
+
+			// Commit the processed batch before taking the next one
+			if err := pc.commit(cl); err != nil {
+				log.Error("failed to commit offsets", "offset", pc.pending.Offset+1, "error", err)
 			}
 		}
 	}
 }
 
-type MessageHandler func(key, value []byte) error
+// commit synchronously commits the offset following the last completed record, if any
+func (pc *pconsumer) commit(cl *kgo.Client) error {
+	if pc.pending == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), commitTimeout)
+	defer cancel()
 
+	if err := cl.CommitRecords(ctx, pc.pending); err != nil {
+		return err
+	}
+
+	pc.pending = nil
+	return nil
+}
+
+// MessageHandler processes a single consumed record. The record carries its headers
+// and metadata (topic, partition, offset, timestamp) alongside the key and value. The
+// context is not cancelled when the partition is revoked, so the record can complete;
+// middlewares such as Timeout may give it a deadline.
+type MessageHandler func(ctx context.Context, rec *kgo.Record) error
+
+// splitConsume runs one goroutine per assigned partition, processing its records with the
+// handler registered for the partition's topic, either sequentially or with keyed workers
+// committing the highest offset below which every record completed. It provides at-least-once
+// processing: autocommit is disabled and only offsets of records whose handler completed
+// (or that were parked on the dead-letter topic) are committed, after every processed batch
+// and, for revoked partitions, once their goroutine has stopped. A crash or lost partition
+// can therefore replay records, but never skip one.
 type splitConsume struct {
-	mu        sync.Mutex // gaurds assigning / losing vs. polling
-	consumers map[string]map[int32]pconsumer
-	handler   MessageHandler
+	mu          sync.Mutex // gaurds assigning / losing vs. polling
+	consumers   map[string]map[int32]*pconsumer
+	handlers    *HandlerRegistry
+	workers     int // keyed workers per partition for handlers that do not set their own
+	bufferDepth int // record batches buffered per partition before its fetching is paused
+	deadLetter  *deadLetterQueue
+	metrics     *metrics.Metrics
+	log         *slog.Logger // carries the consumer group
+	closing     bool         // set on shutdown so late assignments do not start new partition consumers
 }
 
-func (s *splitConsume) assigned(_ context.Context, cl *kgo.Client, assigned map[string][]int32) {
+// assigned starts a partition consumer for every assigned partition. A partition that still
+// has a consumer, e.g. because it was assigned again without being revoked first, has that
+// consumer stopped and committed before the new one starts, so a partition is never
+// processed by two goroutines at once.
+func (s *splitConsume) assigned(ctx context.Context, cl *kgo.Client, assigned map[string][]int32) {
 	// Lock the mutex to prevent concurrent access to the consumers map
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	// Do not start consuming again once the application is shutting down
+	if s.closing {
+		s.log.Warn("ignoring partitions assigned during shutdown", "assigned", assigned)
+		return
+	}
+
+	s.log.Info("partitions assigned", "assigned", assigned)
+
 	// Iterate through each topic and its assigned partitions
 	for topic, partitions := range assigned {
-		// If this is the first partition for this topic, initialize the map
-		if s.consumers[topic] == nil {
-			s.consumers[topic] = make(map[int32]pconsumer)
+		// Find the handler of this topic; the subscription only covers registered topics
+		route, handler, ok := s.handlers.route(topic)
+		if !ok {
+			s.log.Error("no message handler registered for assigned topic, not consuming it", "topic", topic, "partitions", partitions)
+			continue
 		}
 
 		// For each partition assigned to this consumer...
 		for _, partition := range partitions {
+			// Stop a consumer still running for this partition before replacing it
+			if previous, ok := s.stop(cl, topic, partition); ok {
+				s.log.Warn("partition assigned while its consumer is still running, stopping it first", "topic", topic, "partition", partition)
+				if err := drainAndCommit(ctx, cl, []*pconsumer{previous}); err != nil {
+					s.log.Error("failed to stop the previous partition consumer", "topic", topic, "partition", partition, "error", err)
+				}
+
+				select {
+				case <-previous.done:
+				default:
+					s.log.Error("previous partition consumer is still running, not consuming the partition", "topic", topic, "partition", partition)
+					continue
+				}
+			}
+
 			// Create a new partition consumer with communication channels
-			pc := pconsumer{
-				quit: make(chan struct{}),          // Channel to signal shutdown
-				recs: make(chan []*kgo.Record, 10), // Buffered channel for records
+			pc := &pconsumer{
+				topic:     topic,
+				partition: partition,
+				quit:      make(chan struct{}),                     // Channel to signal shutdown
+				done:      make(chan struct{}),                     // Channel closed when the goroutine exits
+				recs:      make(chan []*kgo.Record, s.bufferDepth), // Buffered channel for records
 			}
 
-			// Store the partition consumer in the map for later access
+			// Store the partition consumer in the map for later access, creating the
+			// topic's map for its first partition
+			if s.consumers[topic] == nil {
+				s.consumers[topic] = make(map[int32]*pconsumer)
+			}
 			s.consumers[topic][partition] = pc
 
-			// Launch a dedicated goroutine to process this partition
-			go pc.consume(topic, partition, s.handler)
+			// Launch a dedicated goroutine to process this partition, sequentially or with keyed workers
+			log := s.log.With("topic", topic, "partition", partition, "handler", route.name())
+			if workers := cmp.Or(route.workers, s.workers); workers > 1 {
+				go pc.consumeKeyed(cl, log, route, handler, s.deadLetter, workers)
+			} else {
+				go pc.consume(cl, log, route, handler, s.deadLetter)
+			}
 		}
 	}
 }
 
-func (s *splitConsume) lost(_ context.Context, cl *kgo.Client, lost map[string][]int32) {
+// revoked stops the partition consumers of the revoked partitions, waits for them to finish
+// their current batch and commits the offsets they completed before the rebalance proceeds
+func (s *splitConsume) revoked(ctx context.Context, cl *kgo.Client, revoked map[string][]int32) {
 	// Lock the mutex to prevent concurrent access to the consumers map
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	// Iterate through each topic and its lost partitions
-	for topic, partitions := range lost {
-		// Get the map of partition consumers for this topic
-		ptopics := s.consumers[topic]
+	s.log.Info("partitions revoked", "revoked", revoked)
 
-		// For each partition that was lost...
+	var stopping []*pconsumer
+	for topic, partitions := range revoked {
 		for _, partition := range partitions {
-			// Get the partition consumer object
-			pc := ptopics[partition]
+			if pc, ok := s.stop(cl, topic, partition); ok {
+				stopping = append(stopping, pc)
+			}
+		}
+	}
+
+	if err := drainAndCommit(ctx, cl, stopping); err != nil {
+		s.log.Error("failed to commit offsets of revoked partitions", "revoked", revoked, "error", err)
+	}
+}
+
+// stop removes the consumer of a partition and signals it to quit, without waiting for it.
+// The caller must hold mu.
+func (s *splitConsume) stop(cl *kgo.Client, topic string, partition int32) (*pconsumer, bool) {
+	ptopics := s.consumers[topic]
+	pc, ok := ptopics[partition]
+	if !ok {
+		return nil, false
+	}
+
+	delete(ptopics, partition)
+	if len(ptopics) == 0 {
+		delete(s.consumers, topic)
+	}
+
+	close(pc.quit)
+	pc.release(cl)
+	return pc, true
+}
+
+// consumer returns the consumer of a partition, if it has one
+func (s *splitConsume) consumer(topic string, partition int32) (*pconsumer, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pc, ok := s.consumers[topic][partition]
+	return pc, ok
+}
+
+// Assignments returns a copy of the partitions that currently have a running partition consumer
+func (s *splitConsume) Assignments() map[string][]int32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	assignments := make(map[string][]int32, len(s.consumers))
+	for topic, ptopics := range s.consumers {
+		for partition := range ptopics {
+			assignments[topic] = append(assignments[topic], partition)
+		}
+	}
+
+	return assignments
+}
+
+// Backlog returns the record batches waiting for each partition consumer and whether
+// fetching of the partition is paused
+func (s *splitConsume) Backlog() map[string]map[int32]metrics.PartitionBacklog {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	backlog := make(map[string]map[int32]metrics.PartitionBacklog, len(s.consumers))
+	for topic, ptopics := range s.consumers {
+		backlog[topic] = make(map[int32]metrics.PartitionBacklog, len(ptopics))
+		for partition, pc := range ptopics {
+			backlog[topic][partition] = pc.backlog()
+		}
+	}
+
+	return backlog
+}
+
+// shutdown stops every partition consumer once its current batch is done and commits the
+// offsets they completed. Waiting is abandoned when ctx is done.
+func (s *splitConsume) shutdown(ctx context.Context, cl *kgo.Client) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closing = true
+
+	var stopping []*pconsumer
+	for topic, ptopics := range s.consumers {
+		for partition := range ptopics {
+			pc, _ := s.stop(cl, topic, partition)
+			stopping = append(stopping, pc)
+		}
+	}
+
+	return drainAndCommit(ctx, cl, stopping)
+}
 
-			// Remove this partition from the map
-			delete(ptopics, partition)
+// drainAndCommit waits for every stopped partition consumer to exit, then commits what they
+// completed in one request. Consumers that do not exit before ctx is done are not committed.
+func drainAndCommit(ctx context.Context, cl *kgo.Client, stopping []*pconsumer) error {
+	stopped, waitErr := waitStopped(ctx, stopping)
 
-			// If this was the last partition for this topic, clean up the topic entry
-			if len(ptopics) == 0 {
-				delete(s.consumers, topic)
+	var pending []*kgo.Record
+	for _, pc := range stopped {
+		if pc.pending != nil {
+			pending = append(pending, pc.pending)
+		}
+	}
+
+	if len(pending) == 0 {
+		return waitErr
+	}
+
+	commitCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), commitTimeout)
+	defer cancel()
+
+	return errors.Join(waitErr, cl.CommitRecords(commitCtx, pending...))
+}
+
+// waitStopped waits for the stopped partition consumers to exit and returns the ones that
+// did, with an error if ctx is done before all of them exited
+func waitStopped(ctx context.Context, stopping []*pconsumer) ([]*pconsumer, error) {
+	stopped := make([]*pconsumer, 0, len(stopping))
+	var err error
+	for _, pc := range stopping {
+		if err == nil {
+			select {
+			case <-pc.done:
+			case <-ctx.Done():
+				err = fmt.Errorf("partition consumers did not finish their batch in time: %w", ctx.Err())
 			}
+		}
+
+		// once ctx is done, still collect the consumers that have exited in the meantime
+		select {
+		case <-pc.done:
+			stopped = append(stopped, pc)
+		default:
+		}
+	}
+
+	return stopped, err
+}
+
+// lost stops the partition consumers of partitions that were lost and waits for them to
+// finish their current record, so the partitions are not still being processed when they
+// are assigned again. Committing is pointless here because the group session is gone, so
+// uncommitted records will be redelivered.
+func (s *splitConsume) lost(ctx context.Context, cl *kgo.Client, lost map[string][]int32) {
+	// Lock the mutex to prevent concurrent access to the consumers map
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-			// Signal the partition consumer goroutine to stop
-			close(pc.quit)
+	s.log.Warn("partitions lost", "lost", lost)
+
+	var stopping []*pconsumer
+	for topic, partitions := range lost {
+		for _, partition := range partitions {
+			if pc, ok := s.stop(cl, topic, partition); ok {
+				stopping = append(stopping, pc)
+			}
 		}
 	}
+
+	if _, err := waitStopped(ctx, stopping); err != nil {
+		s.log.Error("failed to stop consumers of lost partitions", "lost", lost, "error", err)
+	}
 }
 
-func (s *splitConsume) poll(cl *kgo.Client) {
-	// Infinite loop to continuously poll for messages
+func (s *splitConsume) poll(ctx context.Context, cl *kgo.Client) {
+	// Loop until the client is closed or polling is stopped
 	for {
 		// Poll for new messages from Kafka
-		fetches := cl.PollFetches(context.Background())
+		fetches := cl.PollFetches(ctx)
 
 		// Check if the client has been closed
 		if fetches.IsClientClosed() {
-			fmt.Println("Client is closed, stopping consumption")
+			s.log.Info("client is closed, stopping consumption")
+			return
+		}
+
+		// Check if polling was stopped for shutdown
+		if ctx.Err() != nil {
+			s.log.Info("polling stopped, stopping consumption")
 			return
 		}
 
 		// Handle any errors in the fetched data
-		fetches.EachError(func(_ string, _ int32, err error) {
-			//TODO: Handle errors appropriately, e.g., log them
-			fmt.Println("Error in fetches:", err)
+		fetches.EachError(func(topic string, partition int32, err error) {
+			s.log.Error("fetch error", "topic", topic, "partition", partition, "error", err)
 		})
 
-		// Process each topic in the fetched data
+		// Process each partition in the fetched data
 		fetches.EachTopic(func(t kgo.FetchTopic) {
-			// Safely get the consumers for this topic
-			s.mu.Lock()
-			tconsumers := s.consumers[t.Topic]
-			s.mu.Unlock()
-
-			// If there are no consumers for this topic, skip it
-			if tconsumers == nil {
-				return
-			}
-
-			// Process each partition in this topic
 			t.EachPartition(func(p kgo.FetchPartition) {
-				// Get the consumer for this partition
-				pc, ok := tconsumers[p.Partition]
+				// Safely get the consumer for this partition; records of partitions without
+				// one are dropped, they are fetched again by whoever is assigned the partition
+				pc, ok := s.consumer(t.Topic, p.Partition)
 				if !ok {
 					return
 				}
 
-				// Try to send records to the partition consumer or check if it's quitting
-				select {
-				case pc.recs <- p.Records: // Send records to the partition consumer
-				case <-pc.quit: // Check if the consumer is shutting down
-				}
+				// Hand the records to the partition consumer, pausing the partition if it fell behind
+				s.metrics.ObserveConsumed(t.Topic, p.Partition, len(p.Records))
+				pc.deliver(cl, p.Records)
 			})
 		})
 	}
 }
 
-func setUpKafka(appConfig *config_models.AppConfiguration, handler MessageHandler) *kgo.Client {
-	s := &splitConsume{
-		consumers: make(map[string]map[int32]pconsumer),
-		handler:   handler,
+// instrumentation records the latency and errors of every handler invocation
+func instrumentation(m *metrics.Metrics) Middleware {
+	return func(next MessageHandler) MessageHandler {
+		return func(ctx context.Context, rec *kgo.Record) error {
+			start := time.Now()
+			err := next(ctx, rec)
+			m.ObserveHandler(rec.Topic, rec.Partition, time.Since(start), err)
+			return err
+		}
+	}
+}
+
+// kafkaRuntime owns the Kafka client and the consumer processing the registered handlers:
+// the split consumer polling the client, or in transactional mode the transactional
+// consumer polling its own session
+type kafkaRuntime struct {
+	client         *kgo.Client
+	consumer       *splitConsume     // nil in transactional mode
+	transact       *transactConsumer // nil unless kafka.transactions is enabled
+	deadLetter     *deadLetterQueue
+	connectionOpts []kgo.Opt // seed brokers, TLS and SASL shared by every client
+	stopPoll       context.CancelFunc
+	polling        chan struct{} // closed once the poll loop has returned
+}
+
+// consumerGroup reports the group membership of the client consuming with the handlers
+type consumerGroup struct {
+	client      *kgo.Client
+	assignments func() map[string][]int32
+}
+
+func (g consumerGroup) GroupMetadata() (string, int32) {
+	return g.client.GroupMetadata()
+}
+
+func (g consumerGroup) Assignments() map[string][]int32 {
+	return g.assignments()
+}
+
+// group returns the consumer group member processing the handlers, for the readiness check
+func (k *kafkaRuntime) group() service.ConsumerGroup {
+	if k.transact != nil {
+		return k.transact
+	}
+	return consumerGroup{client: k.client, assignments: k.consumer.Assignments}
+}
+
+// newClient creates an additional client connected like the main one, for short-lived
+// work such as reading records outside of the consumer group
+func (k *kafkaRuntime) newClient(opts ...kgo.Opt) (*kgo.Client, error) {
+	opts = append(slices.Clone(k.connectionOpts), opts...)
+	opts = append(opts, kgo.WithLogger(logger.NewKafkaLogger(slog.Default())))
+	return kgo.NewClient(opts...)
+}
+
+// shutdown drains in-flight produces, stops polling, lets every partition consumer finish
+// its current batch, commits their offsets and finally closes the client, all bounded by ctx.
+// In transactional mode the transaction in progress is ended before the session leaves the group.
+func (k *kafkaRuntime) shutdown(ctx context.Context) error {
+	var errs []error
+
+	if err := k.client.Flush(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("failed to flush produced records: %w", err))
 	}
 
+	k.stopPoll()
+	select {
+	case <-k.polling:
+	case <-ctx.Done():
+		errs = append(errs, fmt.Errorf("poll loop did not stop in time: %w", ctx.Err()))
+	}
+
+	if k.transact != nil {
+		if err := k.transact.shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("failed to leave consumer group: %w", err))
+		}
+		k.client.Close()
+
+		return errors.Join(errs...)
+	}
+
+	if err := k.consumer.shutdown(ctx, k.client); err != nil {
+		errs = append(errs, fmt.Errorf("failed to stop partition consumers: %w", err))
+	}
+
+	if err := k.client.LeaveGroupContext(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("failed to leave consumer group: %w", err))
+	}
+	k.client.Close()
+
+	return errors.Join(errs...)
+}
+
+// bufferDepth returns the number of record batches buffered per partition consumer
+func bufferDepth(consumer config_models.KafkaConsumer) int {
+	if consumer.BufferDepth <= 0 {
+		return defaultBufferDepth
+	}
+	return consumer.BufferDepth
+}
+
+// setUpKafka connects to the cluster, provisions the topics and starts consuming the topics
+// registered in handlers, in transactional mode when kafka.transactions is enabled
+func setUpKafka(appConfig *config_models.AppConfiguration, handlers *HandlerRegistry, m *metrics.Metrics) *kafkaRuntime {
 	topics := appConfig.Kafka.Topics
 
-	client, err := kgo.NewClient(
-		kgo.SeedBrokers(appConfig.Kafka.Connection.Brokers...),
-		kgo.DefaultProduceTopic(topics.DefaultProducer),
+	connectionOpts, err := kafkaConnectionOptions(appConfig.Kafka.Connection)
+	if err != nil {
+		panic(fmt.Sprintf("failed to configure the Kafka connection: %v", err))
+	}
+
+	// Metrics wrap the configured middlewares so that recovered panics and timeouts are counted
+	handlers.Use(instrumentation(m))
+	handlers.Use(configuredMiddlewares(appConfig.Kafka.Consumer)...)
+
+	deadLetter := newDeadLetterQueue(topics.DeadLetter, appConfig.Kafka.Consumer.Retry)
+	log := slog.With("group", topics.DefaultConsumerGroup)
+
+	if appConfig.Kafka.Transactions.Enabled {
+		return setUpTransactionalKafka(appConfig, handlers, deadLetter, connectionOpts, m, log)
+	}
+
+	consumeOpts, err := handlers.consumeOptions()
+	if err != nil {
+		panic(fmt.Sprintf("failed to subscribe to topics: %v", err))
+	}
+
+	s := &splitConsume{
+		consumers:   make(map[string]map[int32]*pconsumer),
+		handlers:    handlers,
+		workers:     appConfig.Kafka.Consumer.Workers,
+		bufferDepth: bufferDepth(appConfig.Kafka.Consumer),
+		deadLetter:  deadLetter,
+		metrics:     m,
+		log:         log,
+	}
+
+	client := connect(appConfig, m, append(slices.Clone(connectionOpts),
 		kgo.ConsumerGroup(topics.DefaultConsumerGroup),
-		kgo.ConsumeTopics(topics.DefaultConsumer),
+		kgo.DisableAutoCommit(),
 		kgo.OnPartitionsAssigned(s.assigned),
-		kgo.OnPartitionsRevoked(s.lost),
+		kgo.OnPartitionsRevoked(s.revoked),
 		kgo.OnPartitionsLost(s.lost),
-	)
+	), consumeOpts...)
+	// Topics subscribed by pattern are discovered through metadata, so pick up the ones just created
+	client.ForceMetadataRefresh()
+
+	m.RegisterConsumerBacklog(s.Backlog)
+	m.RegisterConsumerLag(kadm.NewClient(client), topics.DefaultConsumerGroup)
+
+	pollCtx, stopPoll := context.WithCancel(context.Background())
+	runtime := &kafkaRuntime{
+		client:         client,
+		consumer:       s,
+		deadLetter:     deadLetter,
+		connectionOpts: connectionOpts,
+		stopPoll:       stopPoll,
+		polling:        make(chan struct{}),
+	}
+
+	// Start the polling in a separate goroutine
+	go func() {
+		defer close(runtime.polling)
+		s.poll(pollCtx, client)
+	}()
+
+	return runtime
+}
+
+// setUpTransactionalKafka connects the client serving the HTTP API outside of the consumer
+// group, provisions the topics and starts the transactional consumer. The client cannot be
+// the transactional one, because that one may only produce inside a transaction.
+func setUpTransactionalKafka(appConfig *config_models.AppConfiguration, handlers *HandlerRegistry, deadLetter *deadLetterQueue, connectionOpts []kgo.Opt, m *metrics.Metrics, log *slog.Logger) *kafkaRuntime {
+	topics := appConfig.Kafka.Topics
+
+	client := connect(appConfig, m, slices.Clone(connectionOpts))
 
+	t := newTransactConsumer(handlers, deadLetter, m, log.With("transactional_id", appConfig.Kafka.Transactions.TransactionalID))
+	session, err := newTransactSession(appConfig, handlers, t, m, connectionOpts)
 	if err != nil {
-		panic(fmt.Sprintf("failed to create Kafka client: %v", err))
+		panic(fmt.Sprintf("failed to create the transactional session: %v", err))
+	}
+	t.session = session
+
+	m.RegisterConsumerLag(kadm.NewClient(client), topics.DefaultConsumerGroup)
+
+	pollCtx, stopPoll := context.WithCancel(context.Background())
+	runtime := &kafkaRuntime{
+		client:         client,
+		transact:       t,
+		deadLetter:     deadLetter,
+		connectionOpts: connectionOpts,
+		stopPoll:       stopPoll,
+		polling:        make(chan struct{}),
 	}
 
-	createTopics(client, topics)
-
-	// Start the polling in a separate goroutine
+	// Start consuming in a separate goroutine
 	go func() {
-		s.poll(client)
+		defer close(runtime.polling)
+		t.run(pollCtx)
 	}()
 
-	return client
+	return runtime
 }
 
-func createTopics(client *kgo.Client, topics config_models.KafkaTopics) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+// connect creates the main client, producing to the default producer topic and instrumented
+// with the Kafka metrics, and provisions the topics
+func connect(appConfig *config_models.AppConfiguration, m *metrics.Metrics, opts []kgo.Opt, consumeOpts ...kgo.Opt) *kgo.Client {
+	opts = append(opts,
+		kgo.DefaultProduceTopic(appConfig.Kafka.Topics.DefaultProducer),
+		kgo.RecordPartitioner(service.RecordPartitioner()),
+		kgo.WithHooks(m.KafkaHooks("main")...),
+		kgo.WithLogger(logger.NewKafkaLogger(slog.Default())),
+	)
 
-	_, err := kadm.NewClient(client).CreateTopics(ctx, 3, -1, nil, topics.DefaultProducer, topics.DefaultConsumer)
+	client, err := kgo.NewClient(append(opts, consumeOpts...)...)
+	if err != nil {
+		panic(fmt.Sprintf("failed to create Kafka client: %v", err))
+	}
 
-	if err != nil && !strings.Contains(err.Error(), "TOPIC_ALREADY_EXISTS") {
-		panic(fmt.Sprintf("failed to create topics %s and/or %s: %v", topics.DefaultProducer, topics.DefaultConsumer, err))
+	if err := provisionTopics(context.Background(), kadm.NewClient(client), appConfig.Kafka.Topics); err != nil {
+		panic(fmt.Sprintf("failed to provision topics: %v", err))
 	}
+
+	return client
 }
@@ -0,0 +1,413 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/geo-gkez/go-pocs/redpanda-poc/internal/config/models"
+	"github.com/geo-gkez/go-pocs/redpanda-poc/internal/metrics"
+	"github.com/twmb/franz-go/pkg/kadm"
+	"github.com/twmb/franz-go/pkg/kfake"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+const testTopic = "test.input"
+
+func newTestCluster(t *testing.T) *kfake.Cluster {
+	t.Helper()
+
+	cluster, err := kfake.NewCluster(kfake.NumBrokers(1), kfake.SeedTopics(3, testTopic))
+	if err != nil {
+		t.Fatalf("failed to start fake cluster: %v", err)
+	}
+	t.Cleanup(cluster.Close)
+
+	return cluster
+}
+
+func newTestClient(t *testing.T, cluster *kfake.Cluster, opts ...kgo.Opt) *kgo.Client {
+	t.Helper()
+
+	client, err := kgo.NewClient(append([]kgo.Opt{kgo.SeedBrokers(cluster.ListenAddrs()...)}, opts...)...)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	t.Cleanup(client.Close)
+
+	return client
+}
+
+func testKafkaConfig(cluster *kfake.Cluster) *config_models.AppConfiguration {
+	config := &config_models.AppConfiguration{}
+	config.Kafka.Connection.Brokers = cluster.ListenAddrs()
+	config.Kafka.Topics.DefaultProducer = "test.output"
+	config.Kafka.Topics.DefaultConsumer = testTopic
+	config.Kafka.Topics.DefaultConsumerGroup = "test.group"
+	return config
+}
+
+// newTestSplitConsume builds a split consumer outside of any consumer group, so the
+// rebalance callbacks can be driven directly
+func newTestSplitConsume(handler MessageHandler) *splitConsume {
+	handlers := NewHandlerRegistry()
+	handlers.Handle(testTopic, handler)
+
+	return &splitConsume{
+		consumers:   make(map[string]map[int32]*pconsumer),
+		handlers:    handlers,
+		bufferDepth: defaultBufferDepth,
+		deadLetter:  newDeadLetterQueue("", config_models.RetryPolicy{}),
+		log:         slog.Default(),
+	}
+}
+
+// newTestPartitionConsumer builds a partition consumer of testTopic's partition 0 that is not
+// started yet
+func newTestPartitionConsumer(bufferDepth int) *pconsumer {
+	return &pconsumer{
+		topic:     testTopic,
+		partition: 0,
+		quit:      make(chan struct{}),
+		done:      make(chan struct{}),
+		recs:      make(chan []*kgo.Record, bufferDepth),
+	}
+}
+
+// partitionGuard fails the test when a partition is processed by two goroutines at once
+type partitionGuard struct {
+	t      *testing.T
+	active sync.Map // partition -> *atomic.Int32
+}
+
+func (g *partitionGuard) enter(partition int32) func() {
+	counter, _ := g.active.LoadOrStore(partition, new(atomic.Int32))
+	if n := counter.(*atomic.Int32).Add(1); n > 1 {
+		g.t.Errorf("partition %d processed by %d goroutines at once", partition, n)
+	}
+	return func() { counter.(*atomic.Int32).Add(-1) }
+}
+
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(30 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// async runs fn in a goroutine and returns a channel closed once it returned
+func async(fn func()) <-chan struct{} {
+	returned := make(chan struct{})
+	go func() {
+		defer close(returned)
+		fn()
+	}()
+	return returned
+}
+
+func TestSplitConsumeLostWaitsForPartitionConsumer(t *testing.T) {
+	cluster := newTestCluster(t)
+	client := newTestClient(t, cluster)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	s := newTestSplitConsume(func(ctx context.Context, rec *kgo.Record) error {
+		close(started)
+		<-release
+		return nil
+	})
+
+	s.assigned(context.Background(), client, map[string][]int32{testTopic: {0}})
+	pc, _ := s.consumer(testTopic, 0)
+	pc.deliver(client, []*kgo.Record{{Topic: testTopic, Partition: 0, Offset: 0}})
+	<-started
+
+	lost := async(func() {
+		s.lost(context.Background(), client, map[string][]int32{testTopic: {0}})
+	})
+
+	select {
+	case <-lost:
+		t.Fatal("lost returned while the partition consumer was still handling a record")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+
+	select {
+	case <-lost:
+	case <-time.After(5 * time.Second):
+		t.Fatal("lost did not return after the partition consumer finished")
+	}
+
+	if _, ok := s.consumer(testTopic, 0); ok {
+		t.Error("lost partition still has a consumer")
+	}
+}
+
+func TestSplitConsumeReassignWaitsForPreviousConsumer(t *testing.T) {
+	cluster := newTestCluster(t)
+	client := newTestClient(t, cluster)
+
+	guard := &partitionGuard{t: t}
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	handled := make(chan int64, 2)
+	s := newTestSplitConsume(func(ctx context.Context, rec *kgo.Record) error {
+		defer guard.enter(rec.Partition)()
+		if rec.Offset == 0 {
+			started <- struct{}{}
+			<-release
+		}
+		handled <- rec.Offset
+		return nil
+	})
+
+	s.assigned(context.Background(), client, map[string][]int32{testTopic: {0}})
+	first, _ := s.consumer(testTopic, 0)
+	first.deliver(client, []*kgo.Record{{Topic: testTopic, Partition: 0, Offset: 0}})
+	<-started
+
+	// the same partition is assigned again without having been revoked
+	reassigned := async(func() {
+		s.assigned(context.Background(), client, map[string][]int32{testTopic: {0}})
+	})
+
+	select {
+	case <-reassigned:
+		t.Fatal("partition reassigned while its previous consumer was still handling a record")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	<-reassigned
+
+	select {
+	case <-first.done:
+	default:
+		t.Fatal("previous partition consumer still running after the reassignment")
+	}
+
+	second, ok := s.consumer(testTopic, 0)
+	if !ok || second == first {
+		t.Fatal("reassigned partition has no new consumer")
+	}
+	second.deliver(client, []*kgo.Record{{Topic: testTopic, Partition: 0, Offset: 1}})
+
+	for _, want := range []int64{0, 1} {
+		select {
+		case got := <-handled:
+			if got != want {
+				t.Errorf("handled offset %d, want %d", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("offset %d was not handled", want)
+		}
+	}
+
+	if err := s.shutdown(context.Background(), client); err != nil {
+		t.Logf("shutdown: %v", err)
+	}
+}
+
+func TestSplitConsumeRebalanceBetweenMembers(t *testing.T) {
+	cluster := newTestCluster(t)
+	config := testKafkaConfig(cluster)
+	producer := newTestClient(t, cluster)
+
+	const records = 300
+
+	guard := &partitionGuard{t: t}
+	var mu sync.Mutex
+	seen := make(map[string]bool)
+	handler := func(ctx context.Context, rec *kgo.Record) error {
+		defer guard.enter(rec.Partition)()
+		time.Sleep(time.Millisecond)
+
+		mu.Lock()
+		seen[fmt.Sprintf("%d/%d", rec.Partition, rec.Offset)] = true
+		mu.Unlock()
+		return nil
+	}
+	processed := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(seen)
+	}
+	produce := func(from, to int) {
+		for i := from; i < to; i++ {
+			rec := &kgo.Record{Topic: testTopic, Key: []byte(fmt.Sprintf("key-%d", i)), Value: []byte("value")}
+			if err := producer.ProduceSync(context.Background(), rec).FirstErr(); err != nil {
+				t.Fatalf("failed to produce: %v", err)
+			}
+		}
+	}
+
+	first := setUpKafka(config, messageHandlersFor(handler), metrics.New())
+	produce(0, records/2)
+	waitFor(t, "the first member to consume", func() bool { return processed() > 0 })
+
+	// a second member joining the group takes over some of the partitions
+	second := setUpKafka(config, messageHandlersFor(handler), metrics.New())
+	produce(records/2, records)
+
+	waitFor(t, "both members to hold partitions", func() bool {
+		return len(first.consumer.Assignments()[testTopic]) > 0 && len(second.consumer.Assignments()[testTopic]) > 0
+	})
+	waitFor(t, "every record to be processed", func() bool { return processed() == records })
+
+	for _, member := range []*kafkaRuntime{first, second} {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := member.shutdown(ctx); err != nil {
+			t.Errorf("failed to shut down a member: %v", err)
+		}
+		cancel()
+	}
+
+	// everything processed has been committed, so nothing would be consumed again
+	admin := kadm.NewClient(producer)
+	committed, err := admin.FetchOffsets(context.Background(), config.Kafka.Topics.DefaultConsumerGroup)
+	if err != nil {
+		t.Fatalf("failed to fetch committed offsets: %v", err)
+	}
+	ends, err := admin.ListEndOffsets(context.Background(), testTopic)
+	if err != nil {
+		t.Fatalf("failed to list end offsets: %v", err)
+	}
+	ends.Each(func(end kadm.ListedOffset) {
+		if offset, _ := committed.Lookup(testTopic, end.Partition); offset.At != end.Offset {
+			t.Errorf("partition %d committed offset %d, want end offset %d", end.Partition, offset.At, end.Offset)
+		}
+	})
+}
+
+func messageHandlersFor(handler MessageHandler) *HandlerRegistry {
+	handlers := NewHandlerRegistry()
+	handlers.Handle(testTopic, handler)
+	return handlers
+}
+
+// pausedPartitions returns the partitions of testTopic whose fetching the client paused
+func pausedPartitions(client *kgo.Client) []int32 {
+	return client.PauseFetchPartitions(nil)[testTopic]
+}
+
+func TestPartitionConsumerPausesSlowPartition(t *testing.T) {
+	cluster := newTestCluster(t)
+	client := newTestClient(t, cluster)
+
+	pc := newTestPartitionConsumer(1)
+	batch := func(offset int64) []*kgo.Record {
+		return []*kgo.Record{{Topic: testTopic, Offset: offset}, {Topic: testTopic, Offset: offset + 1}}
+	}
+
+	// nobody consumes, so the buffer fills up and the following batches are parked
+	for _, offset := range []int64{0, 2, 4} {
+		pc.deliver(client, batch(offset))
+	}
+
+	want := metrics.PartitionBacklog{Batches: 3, Records: 4, Paused: true}
+	if backlog := pc.backlog(); backlog != want {
+		t.Fatalf("backlog = %+v, want %+v", backlog, want)
+	}
+	if paused := pausedPartitions(client); !slices.Equal(paused, []int32{0}) {
+		t.Fatalf("paused partitions = %v, want [0]", paused)
+	}
+
+	// draining the backlog refills the buffer in fetch order
+	take := func(want int64) {
+		t.Helper()
+		if recs := <-pc.recs; recs[0].Offset != want {
+			t.Fatalf("took the batch at offset %d, want %d", recs[0].Offset, want)
+		}
+		pc.unpark(client)
+	}
+
+	take(0)
+	want = metrics.PartitionBacklog{Batches: 2, Records: 2, Paused: true}
+	if backlog := pc.backlog(); backlog != want {
+		t.Fatalf("backlog with a batch still parked = %+v, want %+v", backlog, want)
+	}
+
+	// fetching resumes once no batch is parked anymore
+	take(2)
+	want = metrics.PartitionBacklog{Batches: 1}
+	if backlog := pc.backlog(); backlog != want {
+		t.Errorf("backlog after unparking every batch = %+v, want %+v", backlog, want)
+	}
+	if paused := pausedPartitions(client); len(paused) != 0 {
+		t.Errorf("paused partitions after unparking every batch = %v, want none", paused)
+	}
+	take(4)
+}
+
+func TestPartitionConsumerReleaseResumesPartition(t *testing.T) {
+	cluster := newTestCluster(t)
+	client := newTestClient(t, cluster)
+
+	pc := newTestPartitionConsumer(1)
+	pc.deliver(client, []*kgo.Record{{Topic: testTopic, Offset: 0}})
+	pc.deliver(client, []*kgo.Record{{Topic: testTopic, Offset: 1}})
+	if !pc.backlog().Paused {
+		t.Fatal("partition not paused with a parked batch")
+	}
+
+	close(pc.quit)
+	pc.release(client)
+
+	if backlog := pc.backlog(); backlog.Records != 0 || backlog.Paused {
+		t.Errorf("backlog after release = %+v, want no parked records and not paused", backlog)
+	}
+	if paused := pausedPartitions(client); len(paused) != 0 {
+		t.Errorf("paused partitions after release = %v, want none", paused)
+	}
+
+	// batches delivered once the consumer quits are dropped
+	pc.deliver(client, []*kgo.Record{{Topic: testTopic, Offset: 2}})
+	if backlog := pc.backlog(); backlog.Records != 0 || backlog.Paused {
+		t.Errorf("backlog after delivering to a quitting consumer = %+v, want no parked records and not paused", backlog)
+	}
+}
+
+func TestPartitionConsumerQuitsBeforeBufferedBatches(t *testing.T) {
+	cluster := newTestCluster(t)
+	client := newTestClient(t, cluster)
+
+	for name, start := range map[string]func(pc *pconsumer, handler MessageHandler){
+		"sequential": func(pc *pconsumer, handler MessageHandler) {
+			pc.consume(client, slog.Default(), &handlerRoute{}, handler, newDeadLetterQueue("", config_models.RetryPolicy{}))
+		},
+		"keyed": func(pc *pconsumer, handler MessageHandler) {
+			pc.consumeKeyed(client, slog.Default(), &handlerRoute{}, handler, newDeadLetterQueue("", config_models.RetryPolicy{}), 2)
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			// a full buffer and a closed quit are both ready when the consumer starts
+			for range 20 {
+				pc := newTestPartitionConsumer(1)
+				pc.deliver(client, []*kgo.Record{{Topic: testTopic, Offset: 0}})
+				close(pc.quit)
+
+				var handled atomic.Int32
+				start(pc, func(ctx context.Context, rec *kgo.Record) error {
+					handled.Add(1)
+					return nil
+				})
+
+				if n := handled.Load(); n != 0 {
+					t.Fatalf("handled %d records after quit closed", n)
+				}
+			}
+		})
+	}
+}
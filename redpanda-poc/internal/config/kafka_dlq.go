@@ -0,0 +1,141 @@
+package config
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/geo-gkez/go-pocs/redpanda-poc/internal/config/models"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// Headers added to records parked on the dead-letter topic
+const (
+	dlqErrorHeader           = "dlq-error"
+	dlqSourceTopicHeader     = "dlq-source-topic"
+	dlqSourcePartitionHeader = "dlq-source-partition"
+	dlqSourceOffsetHeader    = "dlq-source-offset"
+	dlqAttemptsHeader        = "dlq-attempts"
+)
+
+// deadLetterProduceTimeout bounds a single attempt to park a record on the dead-letter topic
+const deadLetterProduceTimeout = 10 * time.Second
+
+// deadLetterQueue retries failing records and parks them on the dead-letter topic
+// once the retries are exhausted, so handler failures never drop a record silently
+type deadLetterQueue struct {
+	topic string
+	retry atomic.Pointer[config_models.RetryPolicy] // replaced on configuration reload
+}
+
+func newDeadLetterQueue(topic string, retry config_models.RetryPolicy) *deadLetterQueue {
+	dl := &deadLetterQueue{topic: topic}
+	dl.setRetryPolicy(retry)
+	return dl
+}
+
+// setRetryPolicy changes the retry policy of the records processed from now on
+func (dl *deadLetterQueue) setRetryPolicy(retry config_models.RetryPolicy) {
+	dl.retry.Store(&retry)
+}
+
+// process runs the handler for the record, retrying with backoff on failure, and produces
+// the record to the dead-letter topic once all retries failed. It returns false when quit
+// is closed before the record was either handled or dead-lettered.
+func (dl *deadLetterQueue) process(ctx context.Context, cl *kgo.Client, log *slog.Logger, rec *kgo.Record, handler MessageHandler, quit <-chan struct{}) bool {
+	log = log.With("offset", rec.Offset)
+
+	var err error
+	attempts := 1
+	for ; ; attempts++ {
+		if err = handler(ctx, rec); err == nil {
+			return true
+		}
+
+		if attempts > dl.retry.Load().MaxRetries {
+			break
+		}
+
+		log.Warn("message handler failed, retrying", "attempt", attempts, "error", err)
+		if !sleepOrQuit(dl.backoff(attempts), quit) {
+			return false
+		}
+	}
+
+	if dl.topic == "" {
+		log.Error("message handler failed, no dead-letter topic configured, dropping the record", "attempts", attempts, "error", err)
+		return true
+	}
+
+	log.Error("message handler failed, sending the record to the dead-letter topic", "attempts", attempts, "dead_letter_topic", dl.topic, "error", err)
+
+	dead := dl.record(rec, err, attempts)
+
+	// keep trying to park the record; giving up here would lose it
+	for produceAttempt := 1; ; produceAttempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), deadLetterProduceTimeout)
+		produceErr := cl.ProduceSync(ctx, dead).FirstErr()
+		cancel()
+
+		if produceErr == nil {
+			return true
+		}
+
+		log.Error("failed to produce the record to the dead-letter topic", "dead_letter_topic", dl.topic, "error", produceErr)
+		if !sleepOrQuit(dl.backoff(produceAttempt), quit) {
+			return false
+		}
+	}
+}
+
+// backoff returns the exponential delay before the given retry, capped at MaxBackoff;
+// without a MaxBackoff every retry waits InitialBackoff
+func (dl *deadLetterQueue) backoff(attempt int) time.Duration {
+	retry := dl.retry.Load()
+
+	delay := retry.InitialBackoff
+	for i := 1; i < attempt && delay < retry.MaxBackoff; i++ {
+		delay *= 2
+	}
+
+	if retry.MaxBackoff > 0 && delay > retry.MaxBackoff {
+		return retry.MaxBackoff
+	}
+	return delay
+}
+
+// record copies the failed record for the dead-letter topic, keeping its key, value and
+// headers and describing the failure and the record's origin in additional headers
+func (dl *deadLetterQueue) record(rec *kgo.Record, err error, attempts int) *kgo.Record {
+	headers := make([]kgo.RecordHeader, 0, len(rec.Headers)+5)
+	headers = append(headers, rec.Headers...)
+	headers = append(headers,
+		kgo.RecordHeader{Key: dlqErrorHeader, Value: []byte(err.Error())},
+		kgo.RecordHeader{Key: dlqSourceTopicHeader, Value: []byte(rec.Topic)},
+		kgo.RecordHeader{Key: dlqSourcePartitionHeader, Value: []byte(strconv.FormatInt(int64(rec.Partition), 10))},
+		kgo.RecordHeader{Key: dlqSourceOffsetHeader, Value: []byte(strconv.FormatInt(rec.Offset, 10))},
+		kgo.RecordHeader{Key: dlqAttemptsHeader, Value: []byte(strconv.Itoa(attempts))},
+	)
+
+	return &kgo.Record{
+		Topic:   dl.topic,
+		Key:     rec.Key,
+		Value:   rec.Value,
+		Headers: headers,
+	}
+}
+
+// sleepOrQuit waits for the given duration and returns false if quit is closed first
+func sleepOrQuit(d time.Duration, quit <-chan struct{}) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-quit:
+		return false
+	}
+}
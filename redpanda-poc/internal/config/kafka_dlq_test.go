@@ -0,0 +1,127 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/geo-gkez/go-pocs/redpanda-poc/internal/config/models"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+func TestDeadLetterQueueBackoff(t *testing.T) {
+	tests := []struct {
+		name    string
+		retry   config_models.RetryPolicy
+		attempt int
+		want    time.Duration
+	}{
+		{"first retry waits the initial backoff", config_models.RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}, 1, 100 * time.Millisecond},
+		{"doubles with every attempt", config_models.RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}, 3, 400 * time.Millisecond},
+		{"capped at the max backoff", config_models.RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}, 5, time.Second},
+		{"stays capped for late attempts", config_models.RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}, 60, time.Second},
+		{"constant without a max backoff", config_models.RetryPolicy{InitialBackoff: 100 * time.Millisecond}, 4, 100 * time.Millisecond},
+		{"no backoff configured", config_models.RetryPolicy{}, 3, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dl := newDeadLetterQueue("test.dlq", test.retry)
+			if got := dl.backoff(test.attempt); got != test.want {
+				t.Errorf("backoff(%d) = %s, want %s", test.attempt, got, test.want)
+			}
+		})
+	}
+}
+
+func TestDeadLetterQueueRecord(t *testing.T) {
+	dl := newDeadLetterQueue("test.dlq", config_models.RetryPolicy{})
+	rec := &kgo.Record{
+		Topic:     "test.input",
+		Partition: 2,
+		Offset:    42,
+		Key:       []byte("order-1"),
+		Value:     []byte("created"),
+		Headers:   []kgo.RecordHeader{{Key: "tenant", Value: []byte("acme")}},
+	}
+
+	dead := dl.record(rec, errors.New("boom"), 4)
+
+	if dead.Topic != "test.dlq" {
+		t.Errorf("dead-lettered to %q, want test.dlq", dead.Topic)
+	}
+	if string(dead.Key) != "order-1" || string(dead.Value) != "created" {
+		t.Errorf("dead-lettered %q=%q, want order-1=created", dead.Key, dead.Value)
+	}
+
+	want := map[string]string{
+		"tenant":                 "acme",
+		dlqErrorHeader:           "boom",
+		dlqSourceTopicHeader:     "test.input",
+		dlqSourcePartitionHeader: "2",
+		dlqSourceOffsetHeader:    "42",
+		dlqAttemptsHeader:        "4",
+	}
+	if len(dead.Headers) != len(want) {
+		t.Errorf("dead-lettered record has %d headers, want %d", len(dead.Headers), len(want))
+	}
+	for _, header := range dead.Headers {
+		if value, ok := want[header.Key]; !ok || string(header.Value) != value {
+			t.Errorf("header %s = %q, want %q", header.Key, header.Value, value)
+		}
+	}
+
+	if len(rec.Headers) != 1 {
+		t.Errorf("the original record's headers were modified: %v", rec.Headers)
+	}
+}
+
+func TestDeadLetterQueueProcessRetries(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxRetries int
+		failures   int
+		wantCalls  int
+	}{
+		{"succeeds first time", 2, 0, 1},
+		{"succeeds on a retry", 2, 2, 3},
+		{"gives up after max retries", 2, 10, 3},
+		{"no retries", 0, 10, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// without a dead-letter topic exhausted records are dropped, so no client is needed
+			dl := newDeadLetterQueue("", config_models.RetryPolicy{MaxRetries: test.maxRetries, InitialBackoff: time.Millisecond})
+
+			calls := 0
+			handler := func(ctx context.Context, rec *kgo.Record) error {
+				calls++
+				if calls <= test.failures {
+					return errors.New("boom")
+				}
+				return nil
+			}
+
+			if !dl.process(context.Background(), nil, slog.Default(), &kgo.Record{}, handler, make(chan struct{})) {
+				t.Fatal("process reported quitting")
+			}
+			if calls != test.wantCalls {
+				t.Errorf("handler called %d times, want %d", calls, test.wantCalls)
+			}
+		})
+	}
+}
+
+func TestDeadLetterQueueProcessQuitsDuringBackoff(t *testing.T) {
+	dl := newDeadLetterQueue("", config_models.RetryPolicy{MaxRetries: 5, InitialBackoff: time.Hour})
+	quit := make(chan struct{})
+	close(quit)
+
+	failing := func(ctx context.Context, rec *kgo.Record) error { return errors.New("boom") }
+	if dl.process(context.Background(), nil, slog.Default(), &kgo.Record{}, failing, quit) {
+		t.Error("process returned true although quit was closed during the backoff")
+	}
+}
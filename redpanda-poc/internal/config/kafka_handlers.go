@@ -0,0 +1,233 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// Middleware wraps a MessageHandler with behaviour shared by several handlers
+type Middleware func(next MessageHandler) MessageHandler
+
+// HandlerOption configures a handler registered in a HandlerRegistry
+type HandlerOption func(route *handlerRoute)
+
+// WithMiddleware wraps the handler in the given middlewares, the first one being the outermost
+func WithMiddleware(middlewares ...Middleware) HandlerOption {
+	return func(route *handlerRoute) {
+		route.middlewares = append(route.middlewares, middlewares...)
+	}
+}
+
+// WithConcurrency caps the number of records of the handler's topics that are processed at
+// the same time across all their partitions. Without it every assigned partition is processed
+// concurrently.
+func WithConcurrency(n int) HandlerOption {
+	return func(route *handlerRoute) {
+		if n > 0 {
+			route.slots = make(chan struct{}, n)
+		}
+	}
+}
+
+// WithWorkers processes each partition of the handler's topics with n workers, dispatching
+// records by key so that records with the same key stay in order. It overrides
+// kafka.consumer.workers; 1 processes every partition sequentially.
+func WithWorkers(n int) HandlerOption {
+	return func(route *handlerRoute) {
+		route.workers = n
+	}
+}
+
+// handlerRoute is a topic or topic pattern together with the handler processing its records
+type handlerRoute struct {
+	topic       string         // exact topic name, empty for pattern routes
+	pattern     *regexp.Regexp // nil for exact topic routes
+	handler     MessageHandler
+	middlewares []Middleware
+	slots       chan struct{} // bounds concurrent records, nil when unbounded
+	workers     int           // keyed workers per partition, 0 for the configured default
+}
+
+// name identifies the route in logs
+func (r *handlerRoute) name() string {
+	if r.pattern != nil {
+		return r.pattern.String()
+	}
+	return r.topic
+}
+
+// acquire takes a processing slot, returning false when quit is closed first
+func (r *handlerRoute) acquire(quit <-chan struct{}) bool {
+	if r.slots == nil {
+		return true
+	}
+
+	select {
+	case r.slots <- struct{}{}:
+		return true
+	case <-quit:
+		return false
+	}
+}
+
+// release returns the slot taken by acquire
+func (r *handlerRoute) release() {
+	if r.slots != nil {
+		<-r.slots
+	}
+}
+
+// HandlerRegistry maps consumed topics to their handlers. Topics are registered either by
+// name or by regular expression; the consumer group subscribes to exactly the registered
+// topics, and a record is processed by the handler of its topic or, failing that, by the
+// first registered pattern matching it.
+//
+// Registration must be complete before the registry is passed to setUpKafka.
+type HandlerRegistry struct {
+	topics        map[string]*handlerRoute
+	patterns      []*handlerRoute
+	transactional map[string]TransformHandler // consumed by the transactional consumer instead
+	middlewares   []Middleware                // wrap every handler, outside of its own middlewares
+}
+
+func NewHandlerRegistry() *HandlerRegistry {
+	return &HandlerRegistry{
+		topics:        make(map[string]*handlerRoute),
+		transactional: make(map[string]TransformHandler),
+	}
+}
+
+// Handle registers the handler of a topic. It panics if the topic is empty or already registered.
+func (r *HandlerRegistry) Handle(topic string, handler MessageHandler, opts ...HandlerOption) {
+	if topic == "" {
+		panic("kafka handler registry: empty topic")
+	}
+	if _, ok := r.topics[topic]; ok {
+		panic(fmt.Sprintf("kafka handler registry: topic %q registered twice", topic))
+	}
+
+	r.topics[topic] = newHandlerRoute(handler, opts, func(route *handlerRoute) { route.topic = topic })
+}
+
+// HandleRegex registers the handler of every topic matching the regular expression, including
+// topics created while the application runs. It panics if the expression does not compile or
+// is already registered.
+func (r *HandlerRegistry) HandleRegex(expr string, handler MessageHandler, opts ...HandlerOption) {
+	pattern := regexp.MustCompile(expr)
+	if slices.ContainsFunc(r.patterns, func(route *handlerRoute) bool { return route.pattern.String() == expr }) {
+		panic(fmt.Sprintf("kafka handler registry: pattern %q registered twice", expr))
+	}
+
+	r.patterns = append(r.patterns, newHandlerRoute(handler, opts, func(route *handlerRoute) { route.pattern = pattern }))
+}
+
+// HandleTransactional registers the transform handler of a topic consumed in transactional
+// mode, see kafka.transactions. It panics if the topic is empty or already registered.
+func (r *HandlerRegistry) HandleTransactional(topic string, handler TransformHandler) {
+	if topic == "" {
+		panic("kafka handler registry: empty topic")
+	}
+	if handler == nil {
+		panic("kafka handler registry: nil handler")
+	}
+	if _, ok := r.transactional[topic]; ok {
+		panic(fmt.Sprintf("kafka handler registry: topic %q registered twice", topic))
+	}
+
+	r.transactional[topic] = handler
+}
+
+// Use adds middlewares applied to the handlers of every route
+func (r *HandlerRegistry) Use(middlewares ...Middleware) {
+	r.middlewares = append(r.middlewares, middlewares...)
+}
+
+func newHandlerRoute(handler MessageHandler, opts []HandlerOption, target func(route *handlerRoute)) *handlerRoute {
+	if handler == nil {
+		panic("kafka handler registry: nil handler")
+	}
+
+	route := &handlerRoute{handler: handler}
+	target(route)
+	for _, opt := range opts {
+		opt(route)
+	}
+
+	return route
+}
+
+// wrap applies the registry's middlewares to a handler
+func (r *HandlerRegistry) wrap(handler MessageHandler) MessageHandler {
+	for i := len(r.middlewares) - 1; i >= 0; i-- {
+		handler = r.middlewares[i](handler)
+	}
+	return handler
+}
+
+// route returns the route processing the records of a topic and its handler wrapped in the
+// registry's and the route's middlewares
+func (r *HandlerRegistry) route(topic string) (*handlerRoute, MessageHandler, bool) {
+	route, ok := r.topics[topic]
+	if !ok {
+		i := slices.IndexFunc(r.patterns, func(route *handlerRoute) bool { return route.pattern.MatchString(topic) })
+		if i < 0 {
+			return nil, nil, false
+		}
+		route = r.patterns[i]
+	}
+
+	handler := route.handler
+	for i := len(route.middlewares) - 1; i >= 0; i-- {
+		handler = route.middlewares[i](handler)
+	}
+
+	return route, r.wrap(handler), true
+}
+
+// consumeOptions subscribes the client to the registered topics. As soon as one pattern is
+// registered every topic is given as a regular expression, so exact names are anchored and quoted.
+func (r *HandlerRegistry) consumeOptions() ([]kgo.Opt, error) {
+	if len(r.topics) == 0 && len(r.patterns) == 0 {
+		return nil, fmt.Errorf("no message handlers registered")
+	}
+	if len(r.transactional) > 0 {
+		return nil, fmt.Errorf("transactional handlers registered while kafka.transactions is disabled")
+	}
+
+	topics := make([]string, 0, len(r.topics)+len(r.patterns))
+	for topic := range r.topics {
+		topics = append(topics, topic)
+	}
+	slices.Sort(topics)
+
+	if len(r.patterns) == 0 {
+		return []kgo.Opt{kgo.ConsumeTopics(topics...)}, nil
+	}
+
+	for i, topic := range topics {
+		topics[i] = "^" + regexp.QuoteMeta(topic) + "$"
+	}
+	for _, route := range r.patterns {
+		topics = append(topics, route.pattern.String())
+	}
+
+	return []kgo.Opt{kgo.ConsumeTopics(topics...), kgo.ConsumeRegex()}, nil
+}
+
+// transactOptions subscribes the transactional session to the topics registered with
+// HandleTransactional. Plain handlers cannot take part in a transaction, so they are rejected.
+func (r *HandlerRegistry) transactOptions() ([]kgo.Opt, error) {
+	if len(r.transactional) == 0 {
+		return nil, fmt.Errorf("no transactional handlers registered")
+	}
+	if len(r.topics) > 0 || len(r.patterns) > 0 {
+		return nil, fmt.Errorf("plain message handlers registered while kafka.transactions is enabled")
+	}
+
+	topics := slices.Sorted(maps.Keys(r.transactional))
+	return []kgo.Opt{kgo.ConsumeTopics(topics...)}, nil
+}
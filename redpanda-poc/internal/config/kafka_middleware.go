@@ -0,0 +1,101 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"time"
+
+	"github.com/geo-gkez/go-pocs/redpanda-poc/internal/config/models"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// ErrHandlerPanic is returned by the Recovery middleware when the handler panicked
+var ErrHandlerPanic = errors.New("message handler panicked")
+
+// Names of the built-in middlewares that can be listed under kafka.consumer.middleware
+const (
+	middlewareRecovery = "recovery"
+	middlewareLogging  = "logging"
+	middlewareTimeout  = "timeout"
+)
+
+var builtinMiddlewares = []string{middlewareRecovery, middlewareLogging, middlewareTimeout}
+
+// Recovery turns a panic of the handler into an ErrHandlerPanic error, so the record is
+// retried and dead-lettered like any other failure instead of crashing the process
+func Recovery() Middleware {
+	return func(next MessageHandler) MessageHandler {
+		return func(ctx context.Context, rec *kgo.Record) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					slog.Error("message handler panicked",
+						"topic", rec.Topic, "partition", rec.Partition, "offset", rec.Offset,
+						"panic", r, "stack", string(debug.Stack()))
+					err = fmt.Errorf("%w: %v", ErrHandlerPanic, r)
+				}
+			}()
+
+			return next(ctx, rec)
+		}
+	}
+}
+
+// Timeout gives every handler invocation a context that expires after d. Handlers must
+// honour the context for the timeout to take effect; a handler that returns the context
+// error is reported as timed out.
+func Timeout(d time.Duration) Middleware {
+	return func(next MessageHandler) MessageHandler {
+		return func(ctx context.Context, rec *kgo.Record) error {
+			ctx, cancel := context.WithTimeout(ctx, d)
+			defer cancel()
+
+			err := next(ctx, rec)
+			if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return fmt.Errorf("message handler timed out after %s: %w", d, err)
+			}
+			return err
+		}
+	}
+}
+
+// Logging logs every handled record with its outcome and duration, at debug level when
+// the handler succeeded and at warn level when it failed
+func Logging() Middleware {
+	return func(next MessageHandler) MessageHandler {
+		return func(ctx context.Context, rec *kgo.Record) error {
+			start := time.Now()
+			err := next(ctx, rec)
+
+			log := slog.With("topic", rec.Topic, "partition", rec.Partition, "offset", rec.Offset,
+				"key", string(rec.Key), "duration", time.Since(start))
+			if err != nil {
+				log.WarnContext(ctx, "message handler returned an error", "error", err)
+			} else {
+				log.DebugContext(ctx, "message handled")
+			}
+			return err
+		}
+	}
+}
+
+// configuredMiddlewares builds the middlewares listed under kafka.consumer.middleware, in order.
+// Recovery is always installed as the outermost of them, whether it is listed or not, so that
+// a panicking handler never crashes the process. The metrics instrumentation set up by
+// setUpKafka wraps all of them, Recovery included.
+func configuredMiddlewares(consumer config_models.KafkaConsumer) []Middleware {
+	middlewares := make([]Middleware, 0, len(consumer.Middleware)+1)
+	middlewares = append(middlewares, Recovery())
+	for _, name := range consumer.Middleware {
+		switch name {
+		case middlewareLogging:
+			middlewares = append(middlewares, Logging())
+		case middlewareTimeout:
+			middlewares = append(middlewares, Timeout(consumer.HandlerTimeout))
+		}
+	}
+
+	return middlewares
+}
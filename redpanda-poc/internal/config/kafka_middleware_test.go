@@ -0,0 +1,71 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/geo-gkez/go-pocs/redpanda-poc/internal/config/models"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+func TestRecoveryTurnsPanicIntoError(t *testing.T) {
+	handler := Recovery()(func(ctx context.Context, rec *kgo.Record) error {
+		panic("boom")
+	})
+
+	err := handler(context.Background(), &kgo.Record{Topic: testTopic})
+	if !errors.Is(err, ErrHandlerPanic) {
+		t.Fatalf("handler returned %v, want ErrHandlerPanic", err)
+	}
+	if !strings.Contains(err.Error(), "boom") {
+		t.Errorf("error %q does not carry the panic value", err)
+	}
+}
+
+func TestTimeoutCancelsHandlerContext(t *testing.T) {
+	cancelled := make(chan struct{})
+	handler := Timeout(10 * time.Millisecond)(func(ctx context.Context, rec *kgo.Record) error {
+		select {
+		case <-ctx.Done():
+			close(cancelled)
+			return ctx.Err()
+		case <-time.After(5 * time.Second):
+			return nil
+		}
+	})
+
+	err := handler(context.Background(), &kgo.Record{Topic: testTopic})
+
+	select {
+	case <-cancelled:
+	default:
+		t.Fatal("the handler's context was not cancelled")
+	}
+	if !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "timed out after 10ms") {
+		t.Errorf("handler returned %v, want a timeout wrapping context.DeadlineExceeded", err)
+	}
+}
+
+func TestTimeoutKeepsErrorsOfHandlersInTime(t *testing.T) {
+	want := errors.New("boom")
+	handler := Timeout(time.Second)(func(ctx context.Context, rec *kgo.Record) error { return want })
+
+	if err := handler(context.Background(), &kgo.Record{Topic: testTopic}); err != want {
+		t.Errorf("handler returned %v, want %v unchanged", err, want)
+	}
+}
+
+func TestConfiguredMiddlewaresAlwaysRecover(t *testing.T) {
+	for _, listed := range [][]string{nil, {middlewareLogging}, {middlewareLogging, middlewareRecovery}} {
+		handlers := NewHandlerRegistry()
+		handlers.Use(configuredMiddlewares(config_models.KafkaConsumer{Middleware: listed})...)
+		handler := handlers.wrap(func(ctx context.Context, rec *kgo.Record) error { panic("boom") })
+
+		if err := handler(context.Background(), &kgo.Record{Topic: testTopic}); !errors.Is(err, ErrHandlerPanic) {
+			t.Errorf("with middleware %v the handler returned %v, want ErrHandlerPanic", listed, err)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package config
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/geo-gkez/go-pocs/redpanda-poc/internal/config/models"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/sasl"
+	"github.com/twmb/franz-go/pkg/sasl/oauth"
+	"github.com/twmb/franz-go/pkg/sasl/plain"
+	"github.com/twmb/franz-go/pkg/sasl/scram"
+)
+
+// SASL mechanisms supported in kafka.connection.sasl.mechanism
+const (
+	saslPlain       = "PLAIN"
+	saslScramSHA256 = "SCRAM-SHA-256"
+	saslScramSHA512 = "SCRAM-SHA-512"
+	saslOAuthBearer = "OAUTHBEARER"
+)
+
+// kafkaConnectionOptions returns the client options every Kafka client of the application
+// needs to reach the brokers: the seed brokers, TLS and SASL
+func kafkaConnectionOptions(connection config_models.KafkaConnection) ([]kgo.Opt, error) {
+	opts := []kgo.Opt{kgo.SeedBrokers(connection.Brokers...)}
+
+	if connection.TLS.Enabled {
+		tlsConfig, err := newTLSConfig(connection.TLS)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, kgo.DialTLSConfig(tlsConfig))
+	}
+
+	if connection.SASL.Mechanism != "" {
+		mechanism, err := newSASLMechanism(connection.SASL)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, kgo.SASL(mechanism))
+	}
+
+	return opts, nil
+}
+
+func newTLSConfig(config config_models.KafkaTLS) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         config.ServerName,
+		InsecureSkipVerify: config.InsecureSkipVerify,
+	}
+
+	if config.CAFile != "" {
+		pem, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read TLS CA file: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("TLS CA file %s contains no PEM certificates", config.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if config.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+func newSASLMechanism(config config_models.KafkaSASL) (sasl.Mechanism, error) {
+	mechanism := strings.ToUpper(config.Mechanism)
+
+	if mechanism == saslOAuthBearer {
+		if config.TokenFile == "" {
+			return oauth.Auth{Token: config.Token}.AsMechanism(), nil
+		}
+
+		// read the token on every authentication so a rotated token is used on reconnect
+		return oauth.Oauth(func(context.Context) (oauth.Auth, error) {
+			token, err := readSecretFile(config.TokenFile)
+			if err != nil {
+				return oauth.Auth{}, fmt.Errorf("failed to read SASL token file: %w", err)
+			}
+			return oauth.Auth{Token: token}, nil
+		}), nil
+	}
+
+	password := config.Password
+	if config.PasswordFile != "" {
+		var err error
+		if password, err = readSecretFile(config.PasswordFile); err != nil {
+			return nil, fmt.Errorf("failed to read SASL password file: %w", err)
+		}
+	}
+
+	switch mechanism {
+	case saslPlain:
+		return plain.Auth{User: config.Username, Pass: password}.AsMechanism(), nil
+	case saslScramSHA256:
+		return scram.Auth{User: config.Username, Pass: password}.AsSha256Mechanism(), nil
+	case saslScramSHA512:
+		return scram.Auth{User: config.Username, Pass: password}.AsSha512Mechanism(), nil
+	default:
+		return nil, fmt.Errorf("unsupported SASL mechanism %q", config.Mechanism)
+	}
+}
+
+// readSecretFile returns the content of a secret file without the trailing newline
+func readSecretFile(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
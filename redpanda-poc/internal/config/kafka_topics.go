@@ -0,0 +1,197 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/geo-gkez/go-pocs/redpanda-poc/internal/config/models"
+	"github.com/twmb/franz-go/pkg/kadm"
+	"github.com/twmb/franz-go/pkg/kerr"
+)
+
+// Defaults of topics declared without partitions or replication factor
+const (
+	defaultTopicPartitions        = 3
+	defaultTopicReplicationFactor = -1 // broker default
+)
+
+// provisionTimeout bounds the whole topic provisioning at startup
+const provisionTimeout = 10 * time.Second
+
+// topicDefinitions returns the declared topics followed by the default producer, consumer
+// and dead-letter topics that are not declared, with the defaults applied
+func topicDefinitions(topics config_models.KafkaTopics) []config_models.TopicDefinition {
+	definitions := slices.Clone(topics.Definitions)
+
+	for _, name := range []string{topics.DefaultProducer, topics.DefaultConsumer, topics.DeadLetter} {
+		declared := slices.ContainsFunc(definitions, func(definition config_models.TopicDefinition) bool {
+			return definition.Name == name
+		})
+		if name != "" && !declared {
+			definitions = append(definitions, config_models.TopicDefinition{Name: name})
+		}
+	}
+
+	for i := range definitions {
+		if definitions[i].Partitions <= 0 {
+			definitions[i].Partitions = defaultTopicPartitions
+		}
+		if definitions[i].ReplicationFactor <= 0 {
+			definitions[i].ReplicationFactor = defaultTopicReplicationFactor
+		}
+	}
+
+	return definitions
+}
+
+// provisionTopics creates the declared topics that are missing and reports the existing
+// ones that drifted from their declaration. With increasePartitions, existing topics with
+// fewer partitions than declared are grown; other drift is only logged, never changed.
+func provisionTopics(ctx context.Context, admin *kadm.Client, topics config_models.KafkaTopics) error {
+	ctx, cancel := context.WithTimeout(ctx, provisionTimeout)
+	defer cancel()
+
+	definitions := topicDefinitions(topics)
+	names := make([]string, 0, len(definitions))
+	for _, definition := range definitions {
+		names = append(names, definition.Name)
+	}
+
+	details, err := admin.ListTopics(ctx, names...)
+	if err != nil {
+		return fmt.Errorf("failed to list topics: %w", err)
+	}
+
+	var errs []error
+	var existing []config_models.TopicDefinition
+	for _, definition := range definitions {
+		detail := details[definition.Name]
+
+		switch {
+		case errors.Is(detail.Err, kerr.UnknownTopicOrPartition):
+			if err := createTopic(ctx, admin, definition); err != nil {
+				errs = append(errs, err)
+			}
+		case detail.Err != nil:
+			errs = append(errs, fmt.Errorf("failed to describe topic %s: %w", definition.Name, detail.Err))
+		default:
+			existing = append(existing, definition)
+		}
+	}
+
+	if len(existing) > 0 {
+		if err := reconcileTopics(ctx, admin, existing, details, topics.IncreasePartitions); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func createTopic(ctx context.Context, admin *kadm.Client, definition config_models.TopicDefinition) error {
+	configs := make(map[string]*string, len(definition.Configs))
+	for key, value := range definition.Configs {
+		configs[key] = &value
+	}
+
+	_, err := admin.CreateTopic(ctx, definition.Partitions, definition.ReplicationFactor, configs, definition.Name)
+	switch {
+	case errors.Is(err, kerr.TopicAlreadyExists):
+		// created concurrently, e.g. by another instance starting at the same time
+		slog.Info("topic already exists", "topic", definition.Name)
+		return nil
+	case err != nil:
+		return fmt.Errorf("failed to create topic %s: %w", definition.Name, err)
+	}
+
+	slog.Info("created topic", "topic", definition.Name, "partitions", definition.Partitions,
+		"replication_factor", definition.ReplicationFactor, "configs", definition.Configs)
+	return nil
+}
+
+// reconcileTopics compares existing topics with their declaration, logging every mismatch
+// as drift and growing the partitions when allowed
+func reconcileTopics(ctx context.Context, admin *kadm.Client, definitions []config_models.TopicDefinition, details kadm.TopicDetails, increasePartitions bool) error {
+	var errs []error
+
+	for _, definition := range definitions {
+		partitions := details[definition.Name].Partitions
+		log := slog.With("topic", definition.Name)
+
+		switch actual := int32(len(partitions)); {
+		case actual < definition.Partitions && increasePartitions:
+			if _, err := admin.UpdatePartitions(ctx, int(definition.Partitions), definition.Name); err != nil {
+				errs = append(errs, fmt.Errorf("failed to increase the partitions of topic %s to %d: %w", definition.Name, definition.Partitions, err))
+			} else {
+				log.Info("increased topic partitions", "from", actual, "to", definition.Partitions)
+			}
+		case actual != definition.Partitions:
+			log.Warn("topic drift", "setting", "partitions", "declared", definition.Partitions, "actual", actual)
+		}
+
+		if replicas := partitions.NumReplicas(); definition.ReplicationFactor > 0 && replicas != int(definition.ReplicationFactor) {
+			log.Warn("topic drift", "setting", "replication-factor", "declared", definition.ReplicationFactor, "actual", replicas)
+		}
+	}
+
+	configured := make([]string, 0, len(definitions))
+	for _, definition := range definitions {
+		if len(definition.Configs) > 0 {
+			configured = append(configured, definition.Name)
+		}
+	}
+	if len(configured) == 0 {
+		return errors.Join(errs...)
+	}
+
+	resources, err := admin.DescribeTopicConfigs(ctx, configured...)
+	if err != nil {
+		return errors.Join(append(errs, fmt.Errorf("failed to describe topic configs: %w", err))...)
+	}
+
+	for _, definition := range definitions {
+		if len(definition.Configs) == 0 {
+			continue
+		}
+
+		resource, err := resources.On(definition.Name, nil)
+		if err == nil {
+			err = resource.Err
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to describe the configs of topic %s: %w", definition.Name, err))
+			continue
+		}
+
+		reportConfigDrift(definition, resource.Configs)
+	}
+
+	return errors.Join(errs...)
+}
+
+// reportConfigDrift logs every declared topic config whose actual value differs
+func reportConfigDrift(definition config_models.TopicDefinition, configs []kadm.Config) {
+	actual := make(map[string]string, len(configs))
+	for _, config := range configs {
+		if config.Value != nil {
+			actual[config.Key] = *config.Value
+		}
+	}
+
+	keys := make([]string, 0, len(definition.Configs))
+	for key := range definition.Configs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if declared := definition.Configs[key]; actual[key] != declared {
+			slog.Warn("topic drift", "topic", definition.Name, "setting", key, "declared", declared, "actual", actual[key])
+		}
+	}
+}
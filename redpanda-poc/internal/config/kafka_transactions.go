@@ -0,0 +1,423 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/geo-gkez/go-pocs/redpanda-poc/internal/config/logger"
+	"github.com/geo-gkez/go-pocs/redpanda-poc/internal/config/models"
+	"github.com/geo-gkez/go-pocs/redpanda-poc/internal/metrics"
+	"github.com/geo-gkez/go-pocs/redpanda-poc/internal/service"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// TransformHandler processes a record consumed in transactional mode and returns the records
+// derived from it. They are produced in the transaction that commits the consumed record's
+// offset; records without a topic go to kafka.topics.default-producer. Returning an error
+// aborts the transaction, so nothing derived from the batch is visible to read_committed
+// consumers and the batch is consumed again.
+type TransformHandler func(ctx context.Context, rec *kgo.Record) ([]*kgo.Record, error)
+
+// transactionRetryBackoff is the wait before consuming a batch again after its transaction
+// failed to end
+const transactionRetryBackoff = time.Second
+
+// recordID identifies a consumed record across redeliveries
+type recordID struct {
+	topic     string
+	partition int32
+	offset    int64
+}
+
+// transactConsumer provides exactly-once consume-transform-produce processing on top of a
+// GroupTransactSession. Every polled batch is processed in one transaction that produces the
+// records derived by the handlers and commits the consumed offsets, so either both become
+// visible or neither does. A handler error aborts the transaction, which discards the derived
+// records and rewinds consumption to the committed offsets; the batch is processed again after
+// the retry backoff, and a record failing more often than the retry policy allows is
+// dead-lettered within the transaction instead. A rebalance during a batch aborts its
+// transaction too, so the new owner of a partition resumes from the committed offsets. A
+// transaction that fails to end is aborted and its batch consumed again; only when the
+// transactional producer is unusable does the member leave the group.
+type transactConsumer struct {
+	session    *kgo.GroupTransactSession
+	handlers   map[string]MessageHandler // by topic, wrapped in the registry's middlewares
+	deadLetter *deadLetterQueue
+	metrics    *metrics.Metrics
+	log        *slog.Logger // carries the consumer group
+
+	mu          sync.Mutex // guards assignments, failures and stopped
+	assignments map[string][]int32
+	failures    map[recordID]int // failed attempts of records not processed yet
+	stopped     bool             // set once the member left the group on a fatal transaction error
+
+	produceMu  sync.Mutex
+	produceErr error // first failed produce of the current transaction
+}
+
+func newTransactConsumer(handlers *HandlerRegistry, deadLetter *deadLetterQueue, m *metrics.Metrics, log *slog.Logger) *transactConsumer {
+	t := &transactConsumer{
+		handlers:    make(map[string]MessageHandler, len(handlers.transactional)),
+		deadLetter:  deadLetter,
+		metrics:     m,
+		log:         log,
+		assignments: make(map[string][]int32),
+		failures:    make(map[recordID]int),
+	}
+
+	for topic, handler := range handlers.transactional {
+		t.handlers[topic] = handlers.wrap(t.transform(handler))
+	}
+
+	return t
+}
+
+// transform adapts a TransformHandler to a MessageHandler so the middlewares can wrap it,
+// producing the derived records in the current transaction once the handler succeeded
+func (t *transactConsumer) transform(handler TransformHandler) MessageHandler {
+	return func(ctx context.Context, rec *kgo.Record) error {
+		derived, err := handler(ctx, rec)
+		if err != nil {
+			return err
+		}
+
+		for _, out := range derived {
+			t.produce(out)
+		}
+		return nil
+	}
+}
+
+// produce buffers a record in the current transaction, remembering the first failure
+func (t *transactConsumer) produce(rec *kgo.Record) {
+	t.session.Produce(context.Background(), rec, func(rec *kgo.Record, err error) {
+		if err == nil {
+			return
+		}
+
+		t.produceMu.Lock()
+		defer t.produceMu.Unlock()
+		if t.produceErr == nil {
+			t.produceErr = fmt.Errorf("failed to produce to %s: %w", rec.Topic, err)
+		}
+	})
+}
+
+// begin starts the transaction of the next batch
+func (t *transactConsumer) begin() error {
+	t.produceMu.Lock()
+	t.produceErr = nil
+	t.produceMu.Unlock()
+
+	return t.session.Begin()
+}
+
+// flushed waits until every record of the current transaction is produced and returns the
+// first produce failure, which must abort the transaction
+func (t *transactConsumer) flushed() error {
+	ctx, cancel := context.WithTimeout(context.Background(), commitTimeout)
+	defer cancel()
+
+	if err := t.session.Client().Flush(ctx); err != nil {
+		return err
+	}
+
+	t.produceMu.Lock()
+	defer t.produceMu.Unlock()
+	return t.produceErr
+}
+
+// end ends the current transaction, committing it if commit is true and nothing prevents it
+func (t *transactConsumer) end(commit kgo.TransactionEndTry) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), commitTimeout)
+	defer cancel()
+
+	return t.session.End(ctx, commit)
+}
+
+// abort makes sure no transaction is left open after End failed, which it may have before
+// ending the transaction, and rewinds the session to the committed offsets. Aborting when the
+// transaction already ended only rewinds.
+func (t *transactConsumer) abort() error {
+	_, err := t.end(kgo.TryAbort)
+	return err
+}
+
+// run consumes until ctx is cancelled or the client is closed, processing every polled batch
+// in its own transaction. The batch being processed when ctx is cancelled is still ended.
+func (t *transactConsumer) run(ctx context.Context) {
+	for {
+		fetches := t.session.PollFetches(ctx)
+
+		if fetches.IsClientClosed() {
+			t.log.Info("client is closed, stopping consumption")
+			return
+		}
+		if ctx.Err() != nil {
+			t.log.Info("polling stopped, stopping consumption")
+			return
+		}
+
+		fetches.EachError(func(topic string, partition int32, err error) {
+			t.log.Error("fetch error", "topic", topic, "partition", partition, "error", err)
+		})
+
+		records := fetches.NumRecords()
+		if records == 0 {
+			continue
+		}
+
+		// Begin only fails once the producer ID is fenced or failed beyond recovery
+		if err := t.begin(); err != nil {
+			t.fail("failed to begin a transaction", err)
+			return
+		}
+
+		failed, attempts, err := t.process(fetches)
+		commit := failed == nil
+		if commit {
+			if flushErr := t.flushed(); flushErr != nil {
+				t.log.Error("failed to produce the derived records, aborting the transaction", "error", flushErr)
+				commit = false
+			}
+		}
+
+		committed, endErr := t.end(kgo.TransactionEndTry(commit))
+		if endErr != nil {
+			// e.g. a timed out or failed offset commit; once the transaction is aborted the
+			// session is rewound to the committed offsets, so the batch is consumed again
+			if abortErr := t.abort(); abortErr != nil {
+				t.fail("failed to abort the transaction", errors.Join(endErr, abortErr))
+				return
+			}
+
+			t.log.Warn("failed to end the transaction, aborted it, the batch will be consumed again", "records", records, "error", endErr)
+			if !sleepOrQuit(transactionRetryBackoff, ctx.Done()) {
+				return
+			}
+			continue
+		}
+
+		switch {
+		case committed:
+			t.log.Debug("transaction committed", "records", records)
+			// aborted batches are fetched again, so records only count once their transaction committed
+			fetches.EachPartition(func(p kgo.FetchTopicPartition) {
+				t.metrics.ObserveConsumed(p.Topic, p.Partition, len(p.Records))
+			})
+
+		case failed != nil:
+			t.log.Warn("message handler failed, transaction aborted, retrying the batch",
+				"topic", failed.Topic, "partition", failed.Partition, "offset", failed.Offset, "attempt", attempts, "error", err)
+			if !sleepOrQuit(t.deadLetter.backoff(attempts), ctx.Done()) {
+				return
+			}
+
+		default:
+			// a rebalance or failed produce; the batch is consumed again from the committed offsets
+			t.log.Warn("transaction aborted, the batch will be consumed again", "records", records)
+		}
+	}
+}
+
+// process runs the handler of every polled record within the current transaction. It stops at
+// the first record whose handler failed and still has retries left, returning it with its
+// number of failed attempts and the error; the transaction must then be aborted.
+func (t *transactConsumer) process(fetches kgo.Fetches) (*kgo.Record, int, error) {
+	var (
+		failed   *kgo.Record
+		attempts int
+		err      error
+	)
+
+	fetches.EachPartition(func(p kgo.FetchTopicPartition) {
+		if failed != nil {
+			return
+		}
+
+		for _, rec := range p.Records {
+			if attempts, err = t.handle(rec); err != nil {
+				failed = rec
+				return
+			}
+		}
+	})
+
+	return failed, attempts, err
+}
+
+// handle runs the handler of a record, counting its failed attempts across redeliveries. Once
+// they exceed the retry policy, the record is dead-lettered within the transaction and no
+// error is returned.
+func (t *transactConsumer) handle(rec *kgo.Record) (int, error) {
+	handler, ok := t.handlers[rec.Topic]
+	if !ok {
+		t.log.Error("no transactional handler registered for topic, skipping the record", "topic", rec.Topic, "offset", rec.Offset)
+		return 0, nil
+	}
+
+	err := handler(context.Background(), rec)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	id := recordID{topic: rec.Topic, partition: rec.Partition, offset: rec.Offset}
+	if err == nil {
+		delete(t.failures, id)
+		return 0, nil
+	}
+
+	attempts := t.failures[id] + 1
+	if attempts <= t.deadLetter.retry.Load().MaxRetries {
+		t.failures[id] = attempts
+		return attempts, err
+	}
+	delete(t.failures, id)
+
+	log := t.log.With("topic", rec.Topic, "partition", rec.Partition, "offset", rec.Offset)
+	if t.deadLetter.topic == "" {
+		log.Error("message handler failed, no dead-letter topic configured, dropping the record", "attempts", attempts, "error", err)
+		return 0, nil
+	}
+
+	log.Error("message handler failed, sending the record to the dead-letter topic", "attempts", attempts, "dead_letter_topic", t.deadLetter.topic, "error", err)
+	t.produce(t.deadLetter.record(rec, err, attempts))
+	return 0, nil
+}
+
+// fail stops consumption after an error that leaves the transactional producer unusable. The
+// member leaves the group and closes its session, so its partitions move to the other members,
+// and reports no partitions from then on, so the service is no longer ready.
+func (t *transactConsumer) fail(msg string, err error) {
+	t.log.Error(msg+", leaving the consumer group", "error", err)
+
+	t.mu.Lock()
+	t.stopped = true
+	t.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), commitTimeout)
+	defer cancel()
+
+	if err := t.session.Client().LeaveGroupContext(ctx); err != nil {
+		t.log.Error("failed to leave the consumer group", "error", err)
+	}
+	t.session.Close()
+}
+
+func (t *transactConsumer) assigned(_ context.Context, _ *kgo.Client, assigned map[string][]int32) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.log.Info("partitions assigned", "assigned", assigned)
+	for topic, partitions := range assigned {
+		t.assignments[topic] = append(t.assignments[topic], partitions...)
+	}
+}
+
+// revoked forgets the revoked partitions; the session aborts the transaction in progress
+func (t *transactConsumer) revoked(_ context.Context, _ *kgo.Client, revoked map[string][]int32) {
+	t.log.Info("partitions revoked", "revoked", revoked)
+	t.remove(revoked)
+}
+
+// lost forgets the lost partitions; the session aborts the transaction in progress
+func (t *transactConsumer) lost(_ context.Context, _ *kgo.Client, lost map[string][]int32) {
+	t.log.Warn("partitions lost", "lost", lost)
+	t.remove(lost)
+}
+
+// remove drops the assignments and failure counts of partitions this member no longer owns
+func (t *transactConsumer) remove(partitions map[string][]int32) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for topic, removed := range partitions {
+		t.assignments[topic] = slices.DeleteFunc(t.assignments[topic], func(p int32) bool { return slices.Contains(removed, p) })
+		if len(t.assignments[topic]) == 0 {
+			delete(t.assignments, topic)
+		}
+	}
+
+	for id := range t.failures {
+		if slices.Contains(partitions[id.topic], id.partition) {
+			delete(t.failures, id)
+		}
+	}
+}
+
+// GroupMetadata returns the member ID and generation of the session, no member ID once the
+// member left the group on a fatal transaction error
+func (t *transactConsumer) GroupMetadata() (string, int32) {
+	t.mu.Lock()
+	stopped := t.stopped
+	t.mu.Unlock()
+	if stopped {
+		return "", 0
+	}
+
+	return t.session.Client().GroupMetadata()
+}
+
+// Assignments returns a copy of the partitions currently assigned to the session, none once
+// consumption stopped
+func (t *transactConsumer) Assignments() map[string][]int32 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.stopped {
+		return nil
+	}
+
+	assignments := make(map[string][]int32, len(t.assignments))
+	for topic, partitions := range t.assignments {
+		assignments[topic] = slices.Clone(partitions)
+	}
+	return assignments
+}
+
+// shutdown leaves the consumer group and closes the session, unless fail already did. The
+// poll loop must have returned, so no transaction is open.
+func (t *transactConsumer) shutdown(ctx context.Context) error {
+	t.mu.Lock()
+	stopped := t.stopped
+	t.mu.Unlock()
+	if stopped {
+		return nil
+	}
+
+	defer t.session.Close()
+
+	return t.session.Client().LeaveGroupContext(ctx)
+}
+
+// newTransactSession creates the transactional session consuming the topics registered with
+// HandleTransactional in the consumer group, reading only committed records
+func newTransactSession(appConfig *config_models.AppConfiguration, handlers *HandlerRegistry, t *transactConsumer, m *metrics.Metrics, connectionOpts []kgo.Opt) (*kgo.GroupTransactSession, error) {
+	topics := appConfig.Kafka.Topics
+
+	consumeOpts, err := handlers.transactOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	opts := append(slices.Clone(connectionOpts),
+		kgo.TransactionalID(appConfig.Kafka.Transactions.TransactionalID),
+		kgo.DefaultProduceTopic(topics.DefaultProducer),
+		kgo.RecordPartitioner(service.RecordPartitioner()),
+		kgo.ConsumerGroup(topics.DefaultConsumerGroup),
+		kgo.FetchIsolationLevel(kgo.ReadCommitted()),
+		kgo.RequireStableFetchOffsets(),
+		kgo.OnPartitionsAssigned(t.assigned),
+		kgo.OnPartitionsRevoked(t.revoked),
+		kgo.OnPartitionsLost(t.lost),
+		kgo.WithHooks(m.KafkaHooks("transactional")...),
+		kgo.WithLogger(logger.NewKafkaLogger(slog.Default())),
+	)
+
+	return kgo.NewGroupTransactSession(append(opts, consumeOpts...)...)
+}
@@ -0,0 +1,261 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/geo-gkez/go-pocs/redpanda-poc/internal/config/models"
+	"github.com/geo-gkez/go-pocs/redpanda-poc/internal/metrics"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// newTestTransactConsumer builds a transactional consumer of testTopic without a session, so
+// only what does not produce can be driven through it
+func newTestTransactConsumer(handler TransformHandler, deadLetterTopic string, maxRetries int) *transactConsumer {
+	handlers := NewHandlerRegistry()
+	handlers.HandleTransactional(testTopic, handler)
+
+	return newTransactConsumer(handlers, newDeadLetterQueue(deadLetterTopic, config_models.RetryPolicy{MaxRetries: maxRetries}), nil, slog.Default())
+}
+
+// attachTestSession gives the transactional consumer a session with the fake cluster. The
+// cluster does not implement transactions, so no transaction can be begun.
+func attachTestSession(t *testing.T, tc *transactConsumer) {
+	t.Helper()
+
+	session, err := kgo.NewGroupTransactSession(
+		kgo.SeedBrokers(newTestCluster(t).ListenAddrs()...),
+		kgo.TransactionalID("test.transactional"),
+		kgo.ConsumerGroup("test.group"),
+		kgo.ConsumeTopics(testTopic),
+		kgo.OnPartitionsAssigned(tc.assigned),
+		kgo.OnPartitionsRevoked(tc.revoked),
+		kgo.OnPartitionsLost(tc.lost),
+	)
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	t.Cleanup(session.Close)
+
+	tc.session = session
+}
+
+// failing is a transform handler failing for the records selected by fail
+func failing(fail func(rec *kgo.Record) bool) TransformHandler {
+	return func(ctx context.Context, rec *kgo.Record) ([]*kgo.Record, error) {
+		if fail(rec) {
+			return nil, errors.New("boom")
+		}
+		return nil, nil
+	}
+}
+
+func TestTransactConsumerHandleCountsFailures(t *testing.T) {
+	tc := newTestTransactConsumer(failing(func(rec *kgo.Record) bool { return true }), "", 2)
+	rec := &kgo.Record{Topic: testTopic, Partition: 1, Offset: 7}
+
+	for want := 1; want <= 2; want++ {
+		attempts, err := tc.handle(rec)
+		if err == nil || attempts != want {
+			t.Fatalf("handle = %d, %v, want attempt %d with an error", attempts, err, want)
+		}
+	}
+
+	// other records are counted separately
+	if attempts, _ := tc.handle(&kgo.Record{Topic: testTopic, Partition: 1, Offset: 8}); attempts != 1 {
+		t.Errorf("another record failed attempt %d, want 1", attempts)
+	}
+
+	// the retries are exhausted and there is no dead-letter topic, so the record is dropped
+	if attempts, err := tc.handle(rec); err != nil || attempts != 0 {
+		t.Fatalf("handle after the last retry = %d, %v, want the record dropped", attempts, err)
+	}
+	if _, ok := tc.failures[recordID{topic: testTopic, partition: 1, offset: 7}]; ok {
+		t.Error("dropped record still has failures counted")
+	}
+}
+
+func TestTransactConsumerHandleForgetsFailuresOnSuccess(t *testing.T) {
+	calls := 0
+	tc := newTestTransactConsumer(failing(func(rec *kgo.Record) bool {
+		calls++
+		return calls == 1
+	}), "", 3)
+	rec := &kgo.Record{Topic: testTopic, Offset: 3}
+
+	if _, err := tc.handle(rec); err == nil {
+		t.Fatal("first attempt did not fail")
+	}
+	if attempts, err := tc.handle(rec); err != nil || attempts != 0 {
+		t.Fatalf("handle = %d, %v, want success", attempts, err)
+	}
+	if len(tc.failures) != 0 {
+		t.Errorf("failures after a success = %v, want none", tc.failures)
+	}
+}
+
+func TestTransactConsumerHandleDeadLettersAfterMaxRetries(t *testing.T) {
+	tc := newTestTransactConsumer(failing(func(rec *kgo.Record) bool { return true }), "test.input.dlq", 1)
+	attachTestSession(t, tc)
+
+	rec := &kgo.Record{Topic: testTopic, Offset: 5}
+	if attempts, err := tc.handle(rec); err == nil || attempts != 1 {
+		t.Fatalf("handle = %d, %v, want attempt 1 with an error", attempts, err)
+	}
+	if attempts, err := tc.handle(rec); err != nil || attempts != 0 {
+		t.Fatalf("handle after the last retry = %d, %v, want the record dead-lettered", attempts, err)
+	}
+
+	// no transaction was begun, so the dead-lettered record fails to produce, which tells
+	// where it was sent
+	var produceErr error
+	waitFor(t, "the dead-lettered record to be produced", func() bool {
+		produceErr = tc.flushed()
+		return produceErr != nil
+	})
+	if !strings.Contains(produceErr.Error(), "test.input.dlq") {
+		t.Errorf("produce error = %v, want a record produced to test.input.dlq", produceErr)
+	}
+}
+
+func TestTransactConsumerProcessStopsAtFirstFailure(t *testing.T) {
+	var handled []string
+	tc := newTestTransactConsumer(func(ctx context.Context, rec *kgo.Record) ([]*kgo.Record, error) {
+		handled = append(handled, fmt.Sprintf("%d/%d", rec.Partition, rec.Offset))
+		if rec.Partition == 0 && rec.Offset == 1 {
+			return nil, errors.New("boom")
+		}
+		return nil, nil
+	}, "", 3)
+
+	partition := func(partition int32, offsets ...int64) kgo.FetchPartition {
+		p := kgo.FetchPartition{Partition: partition}
+		for _, offset := range offsets {
+			p.Records = append(p.Records, &kgo.Record{Topic: testTopic, Partition: partition, Offset: offset})
+		}
+		return p
+	}
+	fetches := kgo.Fetches{{Topics: []kgo.FetchTopic{{
+		Topic:      testTopic,
+		Partitions: []kgo.FetchPartition{partition(0, 0, 1, 2), partition(1, 0, 1)},
+	}}}}
+
+	failed, attempts, err := tc.process(fetches)
+	if failed == nil || failed.Partition != 0 || failed.Offset != 1 {
+		t.Fatalf("failed record = %v, want partition 0 offset 1", failed)
+	}
+	if attempts != 1 || err == nil {
+		t.Errorf("process = attempt %d, %v, want attempt 1 with an error", attempts, err)
+	}
+	if want := []string{"0/0", "0/1"}; !slices.Equal(handled, want) {
+		t.Errorf("handled %v, want %v", handled, want)
+	}
+}
+
+func TestTransactConsumerRemoveForgetsRevokedPartitions(t *testing.T) {
+	tc := newTestTransactConsumer(failing(func(rec *kgo.Record) bool { return true }), "", 3)
+	tc.assigned(context.Background(), nil, map[string][]int32{testTopic: {0, 1, 2}})
+
+	for partition := range int32(3) {
+		if _, err := tc.handle(&kgo.Record{Topic: testTopic, Partition: partition}); err == nil {
+			t.Fatal("handle did not fail")
+		}
+	}
+
+	tc.revoked(context.Background(), nil, map[string][]int32{testTopic: {0, 2}})
+
+	if assigned := tc.Assignments()[testTopic]; !slices.Equal(assigned, []int32{1}) {
+		t.Errorf("assigned partitions after revoke = %v, want [1]", assigned)
+	}
+	if _, ok := tc.failures[recordID{topic: testTopic, partition: 1}]; !ok || len(tc.failures) != 1 {
+		t.Errorf("failures after revoke = %v, want only partition 1", tc.failures)
+	}
+
+	tc.lost(context.Background(), nil, map[string][]int32{testTopic: {1}})
+
+	if assignments := tc.Assignments(); len(assignments) != 0 {
+		t.Errorf("assignments after losing every partition = %v, want none", assignments)
+	}
+	if len(tc.failures) != 0 {
+		t.Errorf("failures after losing every partition = %v, want none", tc.failures)
+	}
+}
+
+func TestTransactConsumerFailLeavesGroup(t *testing.T) {
+	tc := newTestTransactConsumer(failing(func(rec *kgo.Record) bool { return false }), "", 0)
+	attachTestSession(t, tc)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go tc.session.PollFetches(ctx)
+	waitFor(t, "the session to join the group", func() bool { return len(tc.Assignments()[testTopic]) == 3 })
+	cancel()
+
+	tc.fail("producer fenced", errors.New("boom"))
+
+	if assignments := tc.Assignments(); assignments != nil {
+		t.Errorf("assignments after failing = %v, want none", assignments)
+	}
+	if memberID, _ := tc.GroupMetadata(); memberID != "" {
+		t.Errorf("member ID after failing = %q, want none", memberID)
+	}
+	if len(tc.assignments) != 0 {
+		t.Errorf("partitions still held after leaving the group: %v", tc.assignments)
+	}
+	if fetches := tc.session.PollFetches(context.Background()); !fetches.IsClientClosed() {
+		t.Error("session still open after failing")
+	}
+	if err := tc.shutdown(context.Background()); err != nil {
+		t.Errorf("shutdown after failing: %v", err)
+	}
+}
+
+func TestTransactSessionIsInstrumented(t *testing.T) {
+	cluster := newTestCluster(t)
+	config := testKafkaConfig(cluster)
+	config.Kafka.Transactions.TransactionalID = "test.transactional"
+
+	handlers := NewHandlerRegistry()
+	handlers.HandleTransactional(testTopic, func(ctx context.Context, rec *kgo.Record) ([]*kgo.Record, error) {
+		return []*kgo.Record{{Key: rec.Key, Value: rec.Value}}, nil
+	})
+
+	m := metrics.New()
+	tc := newTransactConsumer(handlers, newDeadLetterQueue("", config_models.RetryPolicy{}), m, slog.Default())
+	session, err := newTransactSession(config, handlers, tc, m, []kgo.Opt{kgo.SeedBrokers(cluster.ListenAddrs()...)})
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	t.Cleanup(session.Close)
+	tc.session = session
+
+	// joining the group connects the session to the broker
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go tc.session.PollFetches(ctx)
+	waitFor(t, "the session to join the group", func() bool { return len(tc.Assignments()[testTopic]) == 3 })
+
+	// the derived record is produced by the session; no transaction was begun, so it fails
+	if _, err := tc.handle(&kgo.Record{Topic: testTopic, Key: []byte("order-1"), Value: []byte("created")}); err != nil {
+		t.Fatalf("handle failed: %v", err)
+	}
+
+	scrape := func() string {
+		recorder := httptest.NewRecorder()
+		m.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		return recorder.Body.String()
+	}
+	waitFor(t, "the derived record to be counted", func() bool {
+		return strings.Contains(scrape(), `redpanda_poc_produce_records_total{result="failure",topic="test.output"} 1`)
+	})
+	if body := scrape(); !strings.Contains(body, `redpanda_poc_kafka_connects_total{client="transactional"`) {
+		t.Errorf("no broker metrics of the transactional session in:\n%s", body)
+	}
+}
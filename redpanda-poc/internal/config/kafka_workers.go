@@ -0,0 +1,178 @@
+package config
+
+import (
+	"context"
+	"hash/fnv"
+	"log/slog"
+	"sync"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// keyedWorkerQueue is the number of records that may wait for each keyed worker
+const keyedWorkerQueue = 64
+
+// trackedRecord is a record dispatched to a keyed worker
+type trackedRecord struct {
+	rec  *kgo.Record
+	done bool
+}
+
+// offsetTracker finds the highest offset below which every dispatched record has completed.
+// Records complete out of order across keys, so an offset may only be committed once all
+// records before it are done.
+type offsetTracker struct {
+	mu       sync.Mutex
+	inFlight []*trackedRecord // in offset order
+	last     *kgo.Record      // last record of the contiguous completed prefix
+	progress chan struct{}    // signalled whenever last moves
+}
+
+func newOffsetTracker() *offsetTracker {
+	return &offsetTracker{progress: make(chan struct{}, 1)}
+}
+
+// add registers a record about to be dispatched
+func (t *offsetTracker) add(rec *kgo.Record) *trackedRecord {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tracked := &trackedRecord{rec: rec}
+	t.inFlight = append(t.inFlight, tracked)
+	return tracked
+}
+
+// complete marks a record as done and advances the contiguous completed prefix
+func (t *offsetTracker) complete(tracked *trackedRecord) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tracked.done = true
+
+	advanced := false
+	for len(t.inFlight) > 0 && t.inFlight[0].done {
+		t.last = t.inFlight[0].rec
+		t.inFlight[0] = nil
+		t.inFlight = t.inFlight[1:]
+		advanced = true
+	}
+
+	if advanced {
+		select {
+		case t.progress <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// committable returns the record whose offset may be committed, or nil if none has completed
+// since the previous call
+func (t *offsetTracker) committable() *kgo.Record {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	last := t.last
+	t.last = nil
+	return last
+}
+
+// consumeKeyed processes the partition with several workers. Records are dispatched to a
+// worker by the hash of their key, so records with the same key are handled in order while
+// different keys are handled concurrently. Records without a key all go to the same worker.
+func (pc *pconsumer) consumeKeyed(cl *kgo.Client, log *slog.Logger, route *handlerRoute, handler MessageHandler, dlq *deadLetterQueue, workers int) {
+	log.Info("starting partition consumer", "workers", workers)
+	// Signal that this partition is no longer being processed
+	defer close(pc.done)
+	// Log when the function exits (stops consuming from this partition)
+	defer log.Info("partition consumer stopped")
+
+	tracker := newOffsetTracker()
+	queues := make([]chan *trackedRecord, workers)
+
+	var wg sync.WaitGroup
+	for i := range queues {
+		queues[i] = make(chan *trackedRecord, keyedWorkerQueue)
+		wg.Add(1)
+		go func(queue <-chan *trackedRecord) {
+			defer wg.Done()
+			pc.work(cl, log, route, handler, dlq, queue, tracker)
+		}(queues[i])
+	}
+
+	// Once quitting, let the workers finish their current record and keep what completed
+	// for the final commit of the revoke or shutdown
+	defer func() {
+		wg.Wait()
+		if last := tracker.committable(); last != nil {
+			pc.pending = last
+		}
+	}()
+
+	for {
+		// Check quit first so no buffered batch is dispatched once quit closed
+		select {
+		case <-pc.quit:
+			log.Info("quitting partition consumer")
+			return
+		default:
+		}
+
+		select {
+		case <-pc.quit:
+			log.Info("quitting partition consumer")
+			return
+
+		case recs := <-pc.recs:
+			pc.unpark(cl)
+
+			for _, rec := range recs {
+				tracked := tracker.add(rec)
+
+				select {
+				case queues[keyedWorker(rec.Key, workers)] <- tracked:
+				case <-pc.quit:
+					log.Info("quitting partition consumer in the middle of a batch", "offset", rec.Offset)
+					return
+				}
+			}
+
+		case <-tracker.progress:
+			if last := tracker.committable(); last != nil {
+				pc.pending = last
+			}
+			if err := pc.commit(cl); err != nil {
+				log.Error("failed to commit offsets", "offset", pc.pending.Offset+1, "error", err)
+			}
+		}
+	}
+}
+
+// work handles the records of one keyed worker in order until the partition consumer quits
+func (pc *pconsumer) work(cl *kgo.Client, log *slog.Logger, route *handlerRoute, handler MessageHandler, dlq *deadLetterQueue, queue <-chan *trackedRecord, tracker *offsetTracker) {
+	for {
+		select {
+		case <-pc.quit:
+			return
+
+		case tracked := <-queue:
+			if !route.acquire(pc.quit) {
+				return
+			}
+			processed := dlq.process(context.Background(), cl, log, tracked.rec, handler, pc.quit)
+			route.release()
+
+			if !processed {
+				return
+			}
+
+			tracker.complete(tracked)
+		}
+	}
+}
+
+// keyedWorker returns the worker handling records with the given key
+func keyedWorker(key []byte, workers int) int {
+	h := fnv.New32a()
+	h.Write(key)
+	return int(h.Sum32() % uint32(workers))
+}
@@ -0,0 +1,186 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/geo-gkez/go-pocs/redpanda-poc/internal/config/models"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+func TestOffsetTrackerCommitsContiguousPrefix(t *testing.T) {
+	tracker := newOffsetTracker()
+
+	var tracked []*trackedRecord
+	for offset := range int64(4) {
+		tracked = append(tracked, tracker.add(&kgo.Record{Offset: offset}))
+	}
+
+	// offsets 1 and 3 complete before offset 0
+	tracker.complete(tracked[1])
+	tracker.complete(tracked[3])
+	if last := tracker.committable(); last != nil {
+		t.Fatalf("offset %d committable while offset 0 is still in flight", last.Offset)
+	}
+
+	tracker.complete(tracked[0])
+	if last := tracker.committable(); last == nil || last.Offset != 1 {
+		t.Fatalf("committable = %v, want offset 1", last)
+	}
+	if last := tracker.committable(); last != nil {
+		t.Fatalf("offset %d committable twice", last.Offset)
+	}
+
+	tracker.complete(tracked[2])
+	if last := tracker.committable(); last == nil || last.Offset != 3 {
+		t.Fatalf("committable = %v, want offset 3", last)
+	}
+}
+
+func TestOffsetTrackerGapHoldsCommitsBack(t *testing.T) {
+	tracker := newOffsetTracker()
+
+	first := tracker.add(&kgo.Record{Offset: 0})
+	gap := tracker.add(&kgo.Record{Offset: 1})
+	var later []*trackedRecord
+	for offset := int64(2); offset < 10; offset++ {
+		later = append(later, tracker.add(&kgo.Record{Offset: offset}))
+	}
+
+	tracker.complete(first)
+	for _, tracked := range later {
+		tracker.complete(tracked)
+	}
+	if last := tracker.committable(); last == nil || last.Offset != 0 {
+		t.Fatalf("committable = %v, want offset 0", last)
+	}
+
+	select {
+	case <-tracker.progress:
+	default:
+		t.Fatal("no progress signalled after offset 0 completed")
+	}
+	select {
+	case <-tracker.progress:
+		t.Fatal("progress signalled while offset 1 holds the others back")
+	default:
+	}
+
+	tracker.complete(gap)
+	if last := tracker.committable(); last == nil || last.Offset != 9 {
+		t.Fatalf("committable = %v, want offset 9", last)
+	}
+}
+
+func TestConsumeKeyedHandlesSameKeyInOrder(t *testing.T) {
+	cluster := newTestCluster(t)
+	client := newTestClient(t, cluster)
+
+	var (
+		mu      sync.Mutex
+		handled = make(map[string][]int64)
+	)
+	handler := func(ctx context.Context, rec *kgo.Record) error {
+		// later records finish faster, so only the per-key ordering keeps them in order
+		time.Sleep(time.Duration(20-rec.Offset) * time.Millisecond)
+
+		mu.Lock()
+		defer mu.Unlock()
+		handled[string(rec.Key)] = append(handled[string(rec.Key)], rec.Offset)
+		return nil
+	}
+
+	pc := newTestPartitionConsumer(defaultBufferDepth)
+	dlq := newDeadLetterQueue("", config_models.RetryPolicy{})
+	go pc.consumeKeyed(client, slog.Default(), &handlerRoute{}, handler, dlq, 4)
+
+	var recs []*kgo.Record
+	for offset := range int64(20) {
+		recs = append(recs, &kgo.Record{Topic: testTopic, Key: fmt.Appendf(nil, "key-%d", offset%4), Offset: offset})
+	}
+	pc.deliver(client, recs)
+
+	waitFor(t, "every record to be handled", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		n := 0
+		for _, offsets := range handled {
+			n += len(offsets)
+		}
+		return n == len(recs)
+	})
+
+	close(pc.quit)
+	<-pc.done
+
+	for key, offsets := range handled {
+		if !slices.IsSorted(offsets) {
+			t.Errorf("records with key %s handled out of order: %v", key, offsets)
+		}
+	}
+
+	// the client is not in a group, so the commit fails and the offset stays pending
+	if pc.pending == nil || pc.pending.Offset != 19 {
+		t.Errorf("pending = %v, want offset 19", pc.pending)
+	}
+}
+
+func TestConsumeKeyedQuitKeepsUnfinishedOffsetsUncommitted(t *testing.T) {
+	cluster := newTestCluster(t)
+	client := newTestClient(t, cluster)
+
+	// two keys handled by different workers
+	const workers = 2
+	fast, slow := []byte("fast"), []byte("slow")
+	for keyedWorker(fast, workers) == keyedWorker(slow, workers) {
+		slow = append(slow, '!')
+	}
+
+	handledFast := make(chan int64, 2)
+	slowFailed := make(chan struct{}, 1)
+	handler := func(ctx context.Context, rec *kgo.Record) error {
+		if string(rec.Key) == string(slow) {
+			select {
+			case slowFailed <- struct{}{}:
+			default:
+			}
+			return errors.New("not yet")
+		}
+		handledFast <- rec.Offset
+		return nil
+	}
+
+	pc := newTestPartitionConsumer(defaultBufferDepth)
+	// the slow record waits in its retry backoff until the consumer quits
+	dlq := newDeadLetterQueue("", config_models.RetryPolicy{MaxRetries: 10, InitialBackoff: time.Hour})
+	go pc.consumeKeyed(client, slog.Default(), &handlerRoute{}, handler, dlq, workers)
+
+	pc.deliver(client, []*kgo.Record{
+		{Topic: testTopic, Key: fast, Offset: 0},
+		{Topic: testTopic, Key: slow, Offset: 1},
+		{Topic: testTopic, Key: fast, Offset: 2},
+	})
+
+	for range 2 {
+		<-handledFast
+	}
+	<-slowFailed
+
+	close(pc.quit)
+	select {
+	case <-pc.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("partition consumer did not stop while a record was in its retry backoff")
+	}
+
+	// offset 2 completed, but offset 1 did not, so only offset 0 may be committed
+	if pc.pending == nil || pc.pending.Offset != 0 {
+		t.Errorf("pending = %v, want offset 0", pc.pending)
+	}
+}
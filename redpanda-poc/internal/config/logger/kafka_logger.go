@@ -0,0 +1,51 @@
+package logger
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// kafkaLogger adapts a slog.Logger to franz-go's kgo.Logger interface
+type kafkaLogger struct {
+	logger *slog.Logger
+}
+
+// NewKafkaLogger returns a kgo.Logger that writes the Kafka client's logs through logger.
+// The client's log level follows the level enabled on the slog handler.
+func NewKafkaLogger(logger *slog.Logger) kgo.Logger {
+	return &kafkaLogger{logger: logger.With("component", "kgo")}
+}
+
+func (l *kafkaLogger) Level() kgo.LogLevel {
+	ctx := context.Background()
+	switch {
+	case l.logger.Enabled(ctx, slog.LevelDebug):
+		return kgo.LogLevelDebug
+	case l.logger.Enabled(ctx, slog.LevelInfo):
+		return kgo.LogLevelInfo
+	case l.logger.Enabled(ctx, slog.LevelWarn):
+		return kgo.LogLevelWarn
+	default:
+		return kgo.LogLevelError
+	}
+}
+
+func (l *kafkaLogger) Log(level kgo.LogLevel, msg string, keyvals ...any) {
+	l.logger.Log(context.Background(), toSlogLevel(level), msg, keyvals...)
+}
+
+// toSlogLevel converts a franz-go log level to slog.Level
+func toSlogLevel(level kgo.LogLevel) slog.Level {
+	switch level {
+	case kgo.LogLevelError:
+		return slog.LevelError
+	case kgo.LogLevelWarn:
+		return slog.LevelWarn
+	case kgo.LogLevelInfo:
+		return slog.LevelInfo
+	default:
+		return slog.LevelDebug
+	}
+}
@@ -8,31 +8,50 @@ import (
 
 var Logger *slog.Logger
 
+// level is shared by every handler so the log level can be changed at runtime
+var level = new(slog.LevelVar)
+
 // LoggerConfig holds the configuration for the logger
 type LoggerConfig struct {
 	Level string
 }
 
 func InitLogger(loggerConfig LoggerConfig) {
+	level.Set(parseLogLevel(loggerConfig.Level))
+
 	Logger = slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
-		Level: parseLogLevel(loggerConfig.Level),
+		Level: level,
 	}))
 
 	slog.SetDefault(Logger)
 }
 
+// SetLevel changes the level of the logger created by InitLogger
+func SetLevel(levelName string) {
+	level.Set(parseLogLevel(levelName))
+}
+
 // parseLogLevel converts a string level to slog.Level
 func parseLogLevel(level string) slog.Level {
+	if parsed, ok := ParseLevel(level); ok {
+		return parsed
+	}
+	return slog.LevelInfo // Default to Info if unrecognized
+}
+
+// ParseLevel converts a string level to slog.Level, reporting whether the level is known.
+// An empty level is accepted as Info
+func ParseLevel(level string) (slog.Level, bool) {
 	switch strings.ToUpper(level) {
 	case "DEBUG":
-		return slog.LevelDebug
-	case "INFO":
-		return slog.LevelInfo
+		return slog.LevelDebug, true
+	case "INFO", "":
+		return slog.LevelInfo, true
 	case "WARN", "WARNING":
-		return slog.LevelWarn
+		return slog.LevelWarn, true
 	case "ERROR":
-		return slog.LevelError
+		return slog.LevelError, true
 	default:
-		return slog.LevelInfo // Default to Info if unrecognized
+		return slog.LevelInfo, false
 	}
 }
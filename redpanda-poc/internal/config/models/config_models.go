@@ -1,6 +1,20 @@
 package config_models
 
-// AppConfig represents the root configuration structure
+import "time"
+
+// AppConfig represents the root configuration structure.
+//
+// Values are resolved in this order, later sources overriding earlier ones:
+//  1. the base file, configs/config.yml or the file given with --config (REDPANDA_POC_CONFIG)
+//  2. the profile overlay next to it, e.g. config.prod.yml for --profile prod (REDPANDA_POC_PROFILE)
+//  3. environment variables named after the key with the REDPANDA_POC prefix, dots and
+//     dashes replaced by underscores, e.g. REDPANDA_POC_KAFKA_CONNECTION_BROKERS
+//     (comma-separated for lists), whether or not the key is present in the files; the
+//     topic definitions can only be given in the files
+//
+// The files are watched while the application runs. Changes of the log level, the rate limit,
+// the producer settings and the consumer retry policy are applied immediately; any other change
+// is ignored with a warning until the next restart.
 type AppConfiguration struct {
 	Kafka  KafkaProperties
 	Server ServerConfiguration
@@ -8,13 +22,48 @@ type AppConfiguration struct {
 
 // KafkaProperties holds all Kafka-related configuration
 type KafkaProperties struct {
-	Connection KafkaConnection
-	Topics     KafkaTopics
+	Connection   KafkaConnection
+	Topics       KafkaTopics
+	Producer     KafkaProducer
+	Consumer     KafkaConsumer
+	Transactions KafkaTransactions
 }
 
 // KafkaConnection holds Kafka connection details
 type KafkaConnection struct {
 	Brokers []string
+	TLS     KafkaTLS
+	SASL    KafkaSASL
+}
+
+// KafkaTLS holds the TLS settings of the broker connections
+type KafkaTLS struct {
+	Enabled bool
+	// CAFile is a PEM bundle of the CAs trusted for the brokers, the system pool is used when empty
+	CAFile string `mapstructure:"ca-file"`
+	// CertFile and KeyFile hold the PEM client certificate and key for mutual TLS
+	CertFile string `mapstructure:"cert-file"`
+	KeyFile  string `mapstructure:"key-file"`
+	// ServerName overrides the host name verified in the broker certificates
+	ServerName string `mapstructure:"server-name"`
+	// InsecureSkipVerify disables the verification of the broker certificates, for development only
+	InsecureSkipVerify bool `mapstructure:"insecure-skip-verify"`
+}
+
+// KafkaSASL holds the SASL authentication settings of the broker connections. Secrets are
+// best given with the *-file keys or the REDPANDA_POC_KAFKA_CONNECTION_SASL_PASSWORD and
+// REDPANDA_POC_KAFKA_CONNECTION_SASL_TOKEN environment variables rather than in the YAML
+type KafkaSASL struct {
+	// Mechanism is one of PLAIN, SCRAM-SHA-256, SCRAM-SHA-512 or OAUTHBEARER; empty disables SASL
+	Mechanism string
+	Username  string
+	Password  string `secret:"true"`
+	// PasswordFile is read instead of Password
+	PasswordFile string `mapstructure:"password-file"`
+	// Token is the OAUTHBEARER token
+	Token string `secret:"true"`
+	// TokenFile is read instead of Token on every authentication, so rotated tokens are picked up
+	TokenFile string `mapstructure:"token-file"`
 }
 
 // KafkaTopics holds Kafka topic configurations
@@ -22,10 +71,106 @@ type KafkaTopics struct {
 	DefaultProducer      string `mapstructure:"default-producer"`
 	DefaultConsumer      string `mapstructure:"default-consumer" `
 	DefaultConsumerGroup string `mapstructure:"default-consumer-group"`
+	// AllowedProducerTopics lists the topics HTTP callers may produce to besides DefaultProducer
+	AllowedProducerTopics []string `mapstructure:"allowed-producer-topics"`
+	// DeadLetter receives consumed records whose handler kept failing; empty disables dead-lettering
+	DeadLetter string `mapstructure:"dead-letter"`
+	// Definitions declares the topics created at startup; the default producer, consumer and
+	// dead-letter topics are created with the defaults of TopicDefinition when not declared
+	Definitions []TopicDefinition
+	// IncreasePartitions adds partitions to existing topics that have fewer than declared
+	IncreasePartitions bool `mapstructure:"increase-partitions"`
+}
+
+// TopicDefinition declares a topic provisioned at startup
+type TopicDefinition struct {
+	Name string
+	// Partitions defaults to 3
+	Partitions int32
+	// ReplicationFactor defaults to the broker default
+	ReplicationFactor int16 `mapstructure:"replication-factor"`
+	// Configs are topic configs such as retention.ms, cleanup.policy or compression.type
+	Configs map[string]string
+}
+
+// KafkaProducer holds the settings of the HTTP produce endpoints; they are reloaded at runtime
+type KafkaProducer struct {
+	// Timeout bounds how long a single produce may wait for the broker
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+// KafkaConsumer holds the settings of the consumer group processing
+type KafkaConsumer struct {
+	Retry RetryPolicy
+	// Middleware lists the built-in middlewares wrapping every message handler, the
+	// first one outermost: recovery, logging and timeout. Recovery is always installed as the
+	// outermost of them, inside the metrics instrumentation; listing it is optional
+	Middleware []string `mapstructure:"middleware"`
+	// HandlerTimeout is the deadline the timeout middleware gives each record
+	HandlerTimeout time.Duration `mapstructure:"handler-timeout"`
+	// Workers processes each partition with that many workers, keeping records with the
+	// same key in order; 0 or 1 processes each partition sequentially
+	Workers int `mapstructure:"workers"`
+	// BufferDepth is the number of fetched record batches buffered per partition; once it
+	// is full, fetching of the partition is paused until its consumer caught up
+	BufferDepth int `mapstructure:"buffer-depth"`
+}
+
+// KafkaTransactions switches the consumer group to exactly-once consume-transform-produce
+// processing: the records derived from consumed records are produced and the consumed offsets
+// committed in one transaction
+type KafkaTransactions struct {
+	Enabled bool
+	// TransactionalID identifies the transactional producer across restarts. It must be unique
+	// per running instance, e.g. set with REDPANDA_POC_KAFKA_TRANSACTIONS_TRANSACTIONAL_ID
+	TransactionalID string `mapstructure:"transactional-id"`
+}
+
+// RetryPolicy controls how often a failing message handler is retried before the record
+// is sent to the dead-letter topic; it is reloaded at runtime
+type RetryPolicy struct {
+	MaxRetries     int           `mapstructure:"max-retries"`
+	InitialBackoff time.Duration `mapstructure:"initial-backoff"`
+	MaxBackoff     time.Duration `mapstructure:"max-backoff"`
 }
 
 type ServerConfiguration struct {
 	Port     int
 	Mode     string
-	LogLevel string
+	LogLevel string `mapstructure:"log_level"`
+	// ShutdownTimeout bounds the whole graceful shutdown (HTTP, produce flush, consumers, commits)
+	ShutdownTimeout time.Duration `mapstructure:"shutdown_timeout"`
+	Readiness       ReadinessConfiguration
+	RateLimit       RateLimitConfiguration `mapstructure:"rate_limit"`
+	Tail            TailConfiguration
+	Admin           AdminConfiguration
+}
+
+// ReadinessConfiguration holds the thresholds of the readiness checks
+type ReadinessConfiguration struct {
+	// ProduceBufferThreshold is the number of buffered produce records at which the service is no longer ready
+	ProduceBufferThreshold int `mapstructure:"produce_buffer_threshold"`
+}
+
+// RateLimitConfiguration caps the rate of produce requests; it is reloaded at runtime
+type RateLimitConfiguration struct {
+	// RequestsPerSecond is the sustained rate of produce requests, 0 disables the limit
+	RequestsPerSecond float64 `mapstructure:"requests_per_second"`
+	// Burst is the number of requests allowed above the sustained rate, defaults to RequestsPerSecond
+	Burst int `mapstructure:"burst"`
+}
+
+// AdminConfiguration guards the admin API, which manages topics and consumer group offsets
+type AdminConfiguration struct {
+	// Enabled registers the /admin endpoints; they are not served by default
+	Enabled bool
+	// Token is the bearer token every admin request must carry, best given with the
+	// REDPANDA_POC_SERVER_ADMIN_TOKEN environment variable rather than in the YAML
+	Token string `secret:"true"`
+}
+
+// TailConfiguration holds the limits of the live tail endpoint
+type TailConfiguration struct {
+	// MaxConcurrent is the number of tails that may run at the same time, defaults to 5
+	MaxConcurrent int `mapstructure:"max_concurrent"`
 }
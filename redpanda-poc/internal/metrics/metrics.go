@@ -0,0 +1,264 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/twmb/franz-go/pkg/kadm"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/plugin/kprom"
+)
+
+// namespace prefixes every metric exposed by the application
+const namespace = "redpanda_poc"
+
+// lagScrapeTimeout bounds the broker round trips needed to compute consumer lag on a scrape
+const lagScrapeTimeout = 5 * time.Second
+
+// Metrics owns the Prometheus registry and every collector of the application
+type Metrics struct {
+	registry *prometheus.Registry
+
+	httpRequests    *prometheus.CounterVec
+	httpDuration    *prometheus.HistogramVec
+	produced        *prometheus.CounterVec
+	produceLatency  *prometheus.HistogramVec
+	consumed        *prometheus.CounterVec
+	handlerDuration *prometheus.HistogramVec
+	handlerErrors   *prometheus.CounterVec
+}
+
+// New creates the application metrics on a dedicated registry, including the Go runtime,
+// process and franz-go broker-level collectors
+func New() *Metrics {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	m := &Metrics{
+		registry: registry,
+
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: "http", Name: "requests_total",
+			Help: "HTTP requests by route, method and status code.",
+		}, []string{"route", "method", "status"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace, Subsystem: "http", Name: "request_duration_seconds",
+			Help:    "HTTP request latency by route and method.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "method"}),
+		produced: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: "produce", Name: "records_total",
+			Help: "Produced records by topic and result (success or failure).",
+		}, []string{"topic", "result"}),
+		produceLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace, Subsystem: "produce", Name: "latency_seconds",
+			Help:    "Time from producing a record until it was acknowledged or failed, by topic.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"topic"}),
+		consumed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: "consume", Name: "records_total",
+			Help: "Consumed records handed to partition consumers, by topic and partition.",
+		}, []string{"topic", "partition"}),
+		handlerDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace, Subsystem: "consume", Name: "handler_duration_seconds",
+			Help:    "Message handler latency by topic and partition.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"topic", "partition"}),
+		handlerErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: "consume", Name: "handler_errors_total",
+			Help: "Message handler invocations that returned an error, by topic and partition.",
+		}, []string{"topic", "partition"}),
+	}
+
+	registry.MustRegister(
+		m.httpRequests,
+		m.httpDuration,
+		m.produced,
+		m.produceLatency,
+		m.consumed,
+		m.handlerDuration,
+		m.handlerErrors,
+	)
+
+	return m
+}
+
+// Handler serves the registry in the Prometheus exposition format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// KafkaHooks returns the hooks to pass to kgo.WithHooks for one of the application's Kafka
+// clients. They collect the franz-go broker metrics, labelled with the given client name, and
+// the per-topic produce results and latency. Every client needs a name of its own, since the
+// broker metrics are registered when the client is created.
+func (m *Metrics) KafkaHooks(client string) []kgo.Hook {
+	broker := kprom.NewMetrics(namespace,
+		kprom.Registry(m.registry),
+		kprom.Subsystem("kafka"),
+		kprom.WithStaticLabel(prometheus.Labels{"client": client}),
+	)
+	return []kgo.Hook{broker, produceHook{m}}
+}
+
+// GinMiddleware records the count and latency of every HTTP request
+func (m *Metrics) GinMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+
+		m.httpRequests.WithLabelValues(route, c.Request.Method, strconv.Itoa(c.Writer.Status())).Inc()
+		m.httpDuration.WithLabelValues(route, c.Request.Method).Observe(time.Since(start).Seconds())
+	}
+}
+
+// ObserveConsumed counts records fetched for a partition and handed to its consumer
+func (m *Metrics) ObserveConsumed(topic string, partition int32, records int) {
+	m.consumed.WithLabelValues(topic, partitionLabel(partition)).Add(float64(records))
+}
+
+// ObserveHandler records the latency and outcome of one message handler invocation
+func (m *Metrics) ObserveHandler(topic string, partition int32, duration time.Duration, err error) {
+	p := partitionLabel(partition)
+	m.handlerDuration.WithLabelValues(topic, p).Observe(duration.Seconds())
+	if err != nil {
+		m.handlerErrors.WithLabelValues(topic, p).Inc()
+	}
+}
+
+// PartitionBacklog is the work waiting for a partition consumer
+type PartitionBacklog struct {
+	Batches int  // fetched record batches not yet taken by the partition consumer
+	Records int  // records in batches parked while fetching of the partition is paused
+	Paused  bool // fetching of the partition is paused until the consumer caught up
+}
+
+// RegisterConsumerBacklog exposes the backlog of each partition consumer, as reported by
+// backlog on every scrape
+func (m *Metrics) RegisterConsumerBacklog(backlog func() map[string]map[int32]PartitionBacklog) {
+	m.registry.MustRegister(&backlogCollector{
+		backlog: backlog,
+		batches: prometheus.NewDesc(prometheus.BuildFQName(namespace, "consume", "buffered_batches"),
+			"Record batches buffered for a partition consumer and not yet processed.",
+			[]string{"topic", "partition"}, nil),
+		parked: prometheus.NewDesc(prometheus.BuildFQName(namespace, "consume", "parked_records"),
+			"Records fetched for a paused partition and waiting for room in its consumer's buffer.",
+			[]string{"topic", "partition"}, nil),
+		paused: prometheus.NewDesc(prometheus.BuildFQName(namespace, "consume", "partition_paused"),
+			"Whether fetching of the partition is paused because its consumer fell behind (1) or not (0).",
+			[]string{"topic", "partition"}, nil),
+	})
+}
+
+// RegisterConsumerLag exposes the lag of the consumer group, computed via kadm on every scrape
+func (m *Metrics) RegisterConsumerLag(admin *kadm.Client, group string) {
+	m.registry.MustRegister(&lagCollector{
+		admin: admin,
+		group: group,
+		desc: prometheus.NewDesc(prometheus.BuildFQName(namespace, "consume", "lag_records"),
+			"Records between the committed offset of the consumer group and the end of the partition.",
+			[]string{"group", "topic", "partition"}, nil),
+	})
+}
+
+func partitionLabel(partition int32) string {
+	return strconv.FormatInt(int64(partition), 10)
+}
+
+// produceHook records every produced record once it is acknowledged or failed
+type produceHook struct {
+	m *Metrics
+}
+
+func (h produceHook) OnProduceRecordUnbuffered(r *kgo.Record, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+
+	h.m.produced.WithLabelValues(r.Topic, result).Inc()
+	if !r.Timestamp.IsZero() {
+		h.m.produceLatency.WithLabelValues(r.Topic).Observe(time.Since(r.Timestamp).Seconds())
+	}
+}
+
+type backlogCollector struct {
+	backlog func() map[string]map[int32]PartitionBacklog
+	batches *prometheus.Desc
+	parked  *prometheus.Desc
+	paused  *prometheus.Desc
+}
+
+func (c *backlogCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.batches
+	ch <- c.parked
+	ch <- c.paused
+}
+
+func (c *backlogCollector) Collect(ch chan<- prometheus.Metric) {
+	for topic, partitions := range c.backlog() {
+		for partition, backlog := range partitions {
+			p := partitionLabel(partition)
+			paused := 0.0
+			if backlog.Paused {
+				paused = 1
+			}
+
+			ch <- prometheus.MustNewConstMetric(c.batches, prometheus.GaugeValue, float64(backlog.Batches), topic, p)
+			ch <- prometheus.MustNewConstMetric(c.parked, prometheus.GaugeValue, float64(backlog.Records), topic, p)
+			ch <- prometheus.MustNewConstMetric(c.paused, prometheus.GaugeValue, paused, topic, p)
+		}
+	}
+}
+
+type lagCollector struct {
+	admin *kadm.Client
+	group string
+	desc  *prometheus.Desc
+}
+
+func (c *lagCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *lagCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), lagScrapeTimeout)
+	defer cancel()
+
+	lags, err := c.admin.Lag(ctx, c.group)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(c.desc, err)
+		return
+	}
+
+	lag, ok := lags[c.group]
+	if !ok {
+		return
+	}
+	if err := lag.Error(); err != nil {
+		ch <- prometheus.NewInvalidMetric(c.desc, err)
+		return
+	}
+
+	for _, member := range lag.Lag.Sorted() {
+		// partitions whose commit or end offset could not be loaded report a lag of -1
+		if member.Err != nil {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(member.Lag), c.group, member.Topic, partitionLabel(member.Partition))
+	}
+}
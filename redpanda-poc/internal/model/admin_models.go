@@ -0,0 +1,108 @@
+package model
+
+// TopicSummary is a topic as listed by the admin API
+type TopicSummary struct {
+	Name              string `json:"name"`
+	Partitions        int    `json:"partitions"`
+	ReplicationFactor int    `json:"replicationFactor"`
+}
+
+// PartitionDescription describes one partition of a topic and the offsets it holds
+type PartitionDescription struct {
+	Partition   int32   `json:"partition"`
+	Leader      int32   `json:"leader"`
+	Replicas    []int32 `json:"replicas"`
+	ISR         []int32 `json:"isr"`
+	StartOffset int64   `json:"startOffset"`
+	EndOffset   int64   `json:"endOffset"`
+}
+
+// TopicDescription describes a topic, its partitions and its configs
+type TopicDescription struct {
+	TopicSummary
+	PartitionDetails []PartitionDescription `json:"partitionDetails"`
+	Configs          map[string]string      `json:"configs"`
+}
+
+// CreateTopicRequest creates a topic; partitions and replication factor default to the broker defaults
+type CreateTopicRequest struct {
+	Name              string            `json:"name" binding:"required"`
+	Partitions        int32             `json:"partitions" binding:"min=0"`
+	ReplicationFactor int16             `json:"replicationFactor" binding:"min=0"`
+	Configs           map[string]string `json:"configs"`
+}
+
+// GroupSummary is a consumer group as listed by the admin API
+type GroupSummary struct {
+	Name         string `json:"name"`
+	State        string `json:"state"`
+	ProtocolType string `json:"protocolType"`
+}
+
+// GroupMember is a member of a consumer group and the partitions assigned to it
+type GroupMember struct {
+	MemberID    string             `json:"memberId"`
+	InstanceID  string             `json:"instanceId,omitempty"`
+	ClientID    string             `json:"clientId"`
+	ClientHost  string             `json:"clientHost"`
+	Assignments map[string][]int32 `json:"assignments"`
+}
+
+// PartitionLag is how far a consumer group is behind on one partition; Lag is -1 when unknown
+type PartitionLag struct {
+	Topic           string `json:"topic"`
+	Partition       int32  `json:"partition"`
+	CommittedOffset int64  `json:"committedOffset"`
+	EndOffset       int64  `json:"endOffset"`
+	Lag             int64  `json:"lag"`
+	MemberID        string `json:"memberId,omitempty"`
+	Error           string `json:"error,omitempty"`
+}
+
+// GroupDescription describes a consumer group, its members and its lag
+type GroupDescription struct {
+	GroupSummary
+	Protocol string         `json:"protocol"`
+	Members  []GroupMember  `json:"members"`
+	Lag      []PartitionLag `json:"lag"`
+	TotalLag int64          `json:"totalLag"`
+}
+
+// Targets of an offset reset
+const (
+	ResetToEarliest  = "earliest"
+	ResetToLatest    = "latest"
+	ResetToTimestamp = "timestamp"
+	ResetToOffset    = "offset"
+)
+
+// ResetOffsetsRequest moves the committed offsets of a consumer group on one topic
+type ResetOffsetsRequest struct {
+	Topic string `json:"topic" binding:"required"`
+	// Partitions limits the reset to these partitions; all partitions of the topic when empty
+	Partitions []int32 `json:"partitions" binding:"omitempty,dive,min=0"`
+	// To is earliest, latest, timestamp or offset
+	To string `json:"to" binding:"required,oneof=earliest latest timestamp offset"`
+	// Timestamp in milliseconds, required when resetting to a timestamp
+	Timestamp *int64 `json:"timestamp"`
+	// Offset is required when resetting to a specific offset
+	Offset *int64 `json:"offset"`
+	// DryRun only computes the new offsets without committing them
+	DryRun bool `json:"dryRun"`
+}
+
+// OffsetReset reports the reset of one partition; PreviousOffset is -1 when nothing was committed
+type OffsetReset struct {
+	Partition      int32  `json:"partition"`
+	PreviousOffset int64  `json:"previousOffset"`
+	NewOffset      int64  `json:"newOffset"`
+	Error          string `json:"error,omitempty"`
+}
+
+// ResetOffsetsResponse reports the offsets a reset committed, or would commit for a dry run
+type ResetOffsetsResponse struct {
+	Group      string        `json:"group"`
+	Topic      string        `json:"topic"`
+	DryRun     bool          `json:"dryRun"`
+	Partitions []OffsetReset `json:"partitions"`
+}
@@ -1,6 +1,68 @@
 package model
 
+import "time"
+
 type ProduceMessageRequest struct {
-	Key     string `json:"key" binding:"required"`
-	Message string `json:"message" binding:"required"`
+	Key     string            `json:"key" binding:"required"`
+	Message string            `json:"message" binding:"required"`
+	Headers map[string]string `json:"headers"`
+	// Topic is optional; the configured default producer topic is used when empty
+	Topic string `json:"topic"`
+}
+
+// ProduceMessageResponse describes where a synchronously produced record was written
+type ProduceMessageResponse struct {
+	Topic     string    `json:"topic"`
+	Partition int32     `json:"partition"`
+	Offset    int64     `json:"offset"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// BatchRecord is a single record of a batch produce request
+type BatchRecord struct {
+	Key       string            `json:"key"`
+	Value     string            `json:"value" binding:"required"`
+	Headers   map[string]string `json:"headers"`
+	Partition *int32            `json:"partition" binding:"omitempty,min=0"`
+	Topic     string            `json:"topic"`
+}
+
+// ProduceBatchRequest holds the records of a batch produce request
+type ProduceBatchRequest struct {
+	Records []BatchRecord `json:"records" binding:"required,min=1,max=1000,dive"`
+}
+
+// ProduceRecordResult reports the outcome of one record of a batch, in request order
+type ProduceRecordResult struct {
+	Index     int       `json:"index"`
+	Topic     string    `json:"topic"`
+	Partition int32     `json:"partition"`
+	Offset    int64     `json:"offset"`
+	Timestamp time.Time `json:"timestamp,omitzero"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// ProduceBatchResponse summarises a batch produce
+type ProduceBatchResponse struct {
+	Succeeded int                   `json:"succeeded"`
+	Failed    int                   `json:"failed"`
+	Results   []ProduceRecordResult `json:"results"`
+}
+
+// Health statuses reported by the health and readiness endpoints
+const (
+	StatusUp   = "UP"
+	StatusDown = "DOWN"
+)
+
+// CheckResult is the outcome of a single readiness check
+type CheckResult struct {
+	Status  string `json:"status"`
+	Details string `json:"details,omitempty"`
+}
+
+// ReadinessResponse aggregates the readiness checks; Status is UP only if every check is UP
+type ReadinessResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
 }
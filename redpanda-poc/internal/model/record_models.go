@@ -0,0 +1,74 @@
+package model
+
+import "time"
+
+// Encodings of record payloads in JSON responses
+const (
+	EncodingUTF8   = "utf8"
+	EncodingBase64 = "base64"
+)
+
+// ReadRecordsQuery selects the records read from one partition of a topic. At most one of
+// FromOffset, FromTimestamp and Last may be set; reading starts at the earliest offset otherwise.
+type ReadRecordsQuery struct {
+	Partition int32 `form:"partition" binding:"min=0"`
+	// FromOffset is the first offset read
+	FromOffset *int64 `form:"fromOffset" binding:"omitempty,min=0"`
+	// FromTimestamp starts at the first record at or after this time, in milliseconds
+	FromTimestamp *int64 `form:"fromTimestamp" binding:"omitempty,min=0"`
+	// Last reads the last N records of the partition
+	Last *int `form:"last" binding:"omitempty,min=1"`
+	// ToOffset stops before this offset
+	ToOffset *int64 `form:"toOffset" binding:"omitempty,min=0"`
+	// ToTimestamp stops at the first record after this time, in milliseconds
+	ToTimestamp *int64 `form:"toTimestamp" binding:"omitempty,min=0"`
+	// Limit caps the number of records returned
+	Limit int `form:"limit" binding:"omitempty,min=1"`
+	// Timeout bounds how long to wait for the records
+	Timeout time.Duration `form:"timeout" binding:"omitempty,min=0"`
+}
+
+// Payload is a record key, value or header value; data that is not valid UTF-8 is base64-encoded
+type Payload struct {
+	Encoding string `json:"encoding"`
+	Data     string `json:"data"`
+}
+
+// ConsumedHeader is a record header; header keys may repeat
+type ConsumedHeader struct {
+	Key   string  `json:"key"`
+	Value Payload `json:"value"`
+}
+
+// ConsumedRecord is a record read from a topic; Key and Value are null when absent
+type ConsumedRecord struct {
+	Topic     string           `json:"topic"`
+	Partition int32            `json:"partition"`
+	Offset    int64            `json:"offset"`
+	Timestamp time.Time        `json:"timestamp"`
+	Key       *Payload         `json:"key"`
+	Value     *Payload         `json:"value"`
+	Headers   []ConsumedHeader `json:"headers"`
+}
+
+// ReadRecordsResponse holds the records read from a partition. NextOffset is where a
+// following read continues; TimedOut is set when the wait ended before the range was read.
+type ReadRecordsResponse struct {
+	Topic      string           `json:"topic"`
+	Partition  int32            `json:"partition"`
+	Records    []ConsumedRecord `json:"records"`
+	NextOffset int64            `json:"nextOffset"`
+	TimedOut   bool             `json:"timedOut"`
+}
+
+// TailRecordsQuery selects the records streamed by a live tail
+type TailRecordsQuery struct {
+	// Partition limits the tail to one partition; all partitions are tailed when nil
+	Partition *int32 `form:"partition" binding:"omitempty,min=0"`
+	// FromOffset starts the tail at this offset instead of the end of the partitions
+	FromOffset *int64 `form:"fromOffset" binding:"omitempty,min=0"`
+	// Key only streams records with exactly this key
+	Key *string `form:"key"`
+	// Headers only streams records carrying every one of these name:value headers
+	Headers []string `form:"header"`
+}
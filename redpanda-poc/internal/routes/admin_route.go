@@ -0,0 +1,188 @@
+package routes
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/geo-gkez/go-pocs/redpanda-poc/internal/config/logger"
+	"github.com/geo-gkez/go-pocs/redpanda-poc/internal/model"
+	"github.com/geo-gkez/go-pocs/redpanda-poc/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/twmb/franz-go/pkg/kerr"
+)
+
+// adminRequestTimeout bounds the broker round trips of a single admin request
+const adminRequestTimeout = 15 * time.Second
+
+// SetupAdminRoutes registers the operational endpoints for topics and consumer groups, each
+// requiring the bearer token; effectiveConfig returns the configuration currently in use, with
+// secrets redacted
+func SetupAdminRoutes(router *gin.Engine, admin service.IAdminService, token string, effectiveConfig func() map[string]any) *gin.Engine {
+	group := router.Group("/admin", requireBearerToken(token), withTimeout(adminRequestTimeout))
+
+	group.GET("/config", func(c *gin.Context) {
+		c.JSON(http.StatusOK, effectiveConfig())
+	})
+
+	group.GET("/topics", func(c *gin.Context) {
+		listTopics(c, admin)
+	})
+	group.POST("/topics", func(c *gin.Context) {
+		createTopic(c, admin)
+	})
+	group.GET("/topics/:topic", func(c *gin.Context) {
+		describeTopic(c, admin)
+	})
+	group.DELETE("/topics/:topic", func(c *gin.Context) {
+		deleteTopic(c, admin)
+	})
+
+	group.GET("/groups", func(c *gin.Context) {
+		listGroups(c, admin)
+	})
+	group.GET("/groups/:group", func(c *gin.Context) {
+		describeGroup(c, admin)
+	})
+	group.POST("/groups/:group/offsets/reset", func(c *gin.Context) {
+		resetOffsets(c, admin)
+	})
+
+	return router
+}
+
+// requireBearerToken rejects requests without an "Authorization: Bearer <token>" header
+// carrying the token with 401 Unauthorized
+func requireBearerToken(token string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		given, ok := strings.CutPrefix(ctx.GetHeader("Authorization"), "Bearer ")
+		if !ok || token == "" || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			ctx.Header("WWW-Authenticate", `Bearer realm="admin"`)
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "missing or invalid admin token"})
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// withTimeout bounds the request context of the following handlers
+func withTimeout(timeout time.Duration) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		timeoutCtx, cancel := context.WithTimeout(ctx.Request.Context(), timeout)
+		defer cancel()
+
+		ctx.Request = ctx.Request.WithContext(timeoutCtx)
+		ctx.Next()
+	}
+}
+
+func listTopics(ctx *gin.Context, adminService service.IAdminService) {
+	topics, err := adminService.ListTopics(ctx.Request.Context())
+	if err != nil {
+		adminError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, topics)
+}
+
+func describeTopic(ctx *gin.Context, adminService service.IAdminService) {
+	topic, err := adminService.DescribeTopic(ctx.Request.Context(), ctx.Param("topic"))
+	if err != nil {
+		adminError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, topic)
+}
+
+func createTopic(ctx *gin.Context, adminService service.IAdminService) {
+	var request model.CreateTopicRequest
+
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	topic, err := adminService.CreateTopic(ctx.Request.Context(), request)
+	if err != nil {
+		adminError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusCreated, topic)
+}
+
+func deleteTopic(ctx *gin.Context, adminService service.IAdminService) {
+	if err := adminService.DeleteTopic(ctx.Request.Context(), ctx.Param("topic")); err != nil {
+		adminError(ctx, err)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+func listGroups(ctx *gin.Context, adminService service.IAdminService) {
+	groups, err := adminService.ListGroups(ctx.Request.Context())
+	if err != nil {
+		adminError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, groups)
+}
+
+func describeGroup(ctx *gin.Context, adminService service.IAdminService) {
+	group, err := adminService.DescribeGroup(ctx.Request.Context(), ctx.Param("group"))
+	if err != nil {
+		adminError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, group)
+}
+
+func resetOffsets(ctx *gin.Context, adminService service.IAdminService) {
+	var request model.ResetOffsetsRequest
+
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	response, err := adminService.ResetOffsets(ctx.Request.Context(), ctx.Param("group"), request)
+	if err != nil {
+		adminError(ctx, err)
+		return
+	}
+
+	if !response.DryRun {
+		logger.FromContext(ctx.Request.Context()).Info("consumer group offsets reset",
+			"group", response.Group, "topic", response.Topic, "to", request.To)
+	}
+	ctx.JSON(http.StatusOK, response)
+}
+
+// adminError logs a failed admin request and responds with the matching status
+func adminError(ctx *gin.Context, err error) {
+	logger.FromContext(ctx.Request.Context()).Warn("admin request failed", "error", err)
+	ctx.JSON(statusForAdminError(err), gin.H{"error": err.Error()})
+}
+
+// statusForAdminError maps an admin operation error to the HTTP status returned to the caller
+func statusForAdminError(err error) int {
+	switch {
+	case errors.Is(err, service.ErrInvalidOffsetReset):
+		return http.StatusBadRequest
+	case errors.Is(err, service.ErrGroupNotFound), errors.Is(err, kerr.GroupIDNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrTopicProtected), errors.Is(err, service.ErrGroupNotEmpty),
+		errors.Is(err, kerr.TopicAlreadyExists), errors.Is(err, kerr.NonEmptyGroup):
+		return http.StatusConflict
+	case errors.Is(err, kerr.InvalidPartitions), errors.Is(err, kerr.InvalidReplicationFactor),
+		errors.Is(err, kerr.InvalidReplicaAssignment), errors.Is(err, kerr.InvalidConfig),
+		errors.Is(err, kerr.InvalidRequest), errors.Is(err, kerr.PolicyViolation):
+		return http.StatusBadRequest
+	case errors.Is(err, kerr.TopicDeletionDisabled), errors.Is(err, kerr.GroupAuthorizationFailed):
+		return http.StatusForbidden
+	default:
+		return statusForKafkaError(err)
+	}
+}
@@ -0,0 +1,33 @@
+package routes
+
+import (
+	"net/http"
+
+	"github.com/geo-gkez/go-pocs/redpanda-poc/internal/model"
+	"github.com/geo-gkez/go-pocs/redpanda-poc/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// SetupHealthRoutes registers the liveness and readiness endpoints used by orchestrators
+func SetupHealthRoutes(router *gin.Engine, health service.IHealthService) *gin.Engine {
+	router.GET("/healthz", liveness)
+	router.GET("/readyz", func(c *gin.Context) {
+		readiness(c, health)
+	})
+	return router
+}
+
+// liveness only reports that the process is able to serve requests
+func liveness(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{"status": model.StatusUp})
+}
+
+func readiness(ctx *gin.Context, healthService service.IHealthService) {
+	response := healthService.Readiness(ctx.Request.Context())
+
+	status := http.StatusOK
+	if response.Status != model.StatusUp {
+		status = http.StatusServiceUnavailable
+	}
+	ctx.JSON(status, response)
+}
@@ -0,0 +1,13 @@
+package routes
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SetupMetricsRoutes exposes the Prometheus metrics served by handler on /metrics
+func SetupMetricsRoutes(router *gin.Engine, handler http.Handler) *gin.Engine {
+	router.GET("/metrics", gin.WrapH(handler))
+	return router
+}
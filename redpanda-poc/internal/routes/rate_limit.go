@@ -0,0 +1,49 @@
+package routes
+
+import (
+	"math"
+	"net/http"
+
+	"github.com/geo-gkez/go-pocs/redpanda-poc/internal/config/models"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+)
+
+// RateLimiter caps the rate of produce requests; its limits can be changed at runtime
+type RateLimiter struct {
+	limiter *rate.Limiter
+}
+
+func NewRateLimiter(config config_models.RateLimitConfiguration) *RateLimiter {
+	l := &RateLimiter{limiter: rate.NewLimiter(rate.Inf, 0)}
+	l.Update(config)
+	return l
+}
+
+// Update applies new limits to the following requests; a zero rate disables limiting
+func (l *RateLimiter) Update(config config_models.RateLimitConfiguration) {
+	if config.RequestsPerSecond <= 0 {
+		l.limiter.SetLimit(rate.Inf)
+		return
+	}
+
+	burst := config.Burst
+	if burst <= 0 {
+		burst = max(1, int(math.Ceil(config.RequestsPerSecond)))
+	}
+
+	l.limiter.SetBurst(burst)
+	l.limiter.SetLimit(rate.Limit(config.RequestsPerSecond))
+}
+
+// Middleware rejects requests above the limit with 429 Too Many Requests
+func (l *RateLimiter) Middleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !l.limiter.Allow() {
+			ctx.Header("Retry-After", "1")
+			ctx.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "rate limit exceeded"})
+			return
+		}
+		ctx.Next()
+	}
+}
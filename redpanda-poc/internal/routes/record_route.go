@@ -0,0 +1,104 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/geo-gkez/go-pocs/redpanda-poc/internal/config/logger"
+	"github.com/geo-gkez/go-pocs/redpanda-poc/internal/model"
+	"github.com/geo-gkez/go-pocs/redpanda-poc/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// tailKeepAliveInterval is how often an idle live tail sends a comment to keep the stream open
+const tailKeepAliveInterval = 15 * time.Second
+
+// SetupRecordRoutes registers the endpoints reading records from topics for inspection
+func SetupRecordRoutes(router *gin.Engine, records service.IRecordService) *gin.Engine {
+	router.GET("/topics/:topic/records", func(c *gin.Context) {
+		readRecords(c, records)
+	})
+	router.GET("/topics/:topic/tail", func(c *gin.Context) {
+		tailRecords(c, records)
+	})
+	return router
+}
+
+func readRecords(ctx *gin.Context, recordService service.IRecordService) {
+	var query model.ReadRecordsQuery
+
+	if err := ctx.ShouldBindQuery(&query); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	response, err := recordService.ReadRecords(ctx.Request.Context(), ctx.Param("topic"), query)
+	if err != nil {
+		logger.FromContext(ctx.Request.Context()).Warn("reading records failed", "topic", ctx.Param("topic"), "error", err)
+		ctx.JSON(statusForRecordError(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
+
+// tailRecords streams the records of a topic as Server-Sent Events until the client
+// disconnects, with a comment sent every tailKeepAliveInterval to keep idle streams open
+func tailRecords(ctx *gin.Context, recordService service.IRecordService) {
+	var query model.TailRecordsQuery
+
+	if err := ctx.ShouldBindQuery(&query); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	tail, err := recordService.TailRecords(ctx.Request.Context(), ctx.Param("topic"), query)
+	if err != nil {
+		logger.FromContext(ctx.Request.Context()).Warn("live tail rejected", "topic", ctx.Param("topic"), "error", err)
+		ctx.JSON(statusForRecordError(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.Header("Content-Type", "text/event-stream")
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Header("Connection", "keep-alive")
+	ctx.Status(http.StatusOK)
+	ctx.Writer.Flush()
+
+	keepAlive := time.NewTicker(tailKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case record, ok := <-tail.Records():
+			if !ok {
+				if err := tail.Err(); err != nil {
+					ctx.SSEvent("error", gin.H{"error": err.Error()})
+					ctx.Writer.Flush()
+				}
+				return
+			}
+			ctx.SSEvent("record", record)
+		case <-keepAlive.C:
+			if _, err := ctx.Writer.WriteString(": keep-alive\n\n"); err != nil {
+				return
+			}
+		}
+		ctx.Writer.Flush()
+	}
+}
+
+// statusForRecordError maps a record read error to the HTTP status returned to the caller
+func statusForRecordError(err error) int {
+	switch {
+	case errors.Is(err, service.ErrInvalidRecordQuery):
+		return http.StatusBadRequest
+	case errors.Is(err, service.ErrPartitionNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrTooManyTails):
+		return http.StatusTooManyRequests
+	default:
+		return statusForKafkaError(err)
+	}
+}
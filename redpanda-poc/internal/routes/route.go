@@ -1,18 +1,34 @@
 package routes
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"strconv"
+	"strings"
 
+	"github.com/geo-gkez/go-pocs/redpanda-poc/internal/config/logger"
 	"github.com/geo-gkez/go-pocs/redpanda-poc/internal/model"
 	"github.com/geo-gkez/go-pocs/redpanda-poc/internal/service"
 	"github.com/gin-gonic/gin"
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kgo"
 )
 
-func SetupRoutesAndRegister(router *gin.Engine, kafka service.IKafkaService) *gin.Engine {
+// produceModeHeader lets callers opt into synchronous produce without a query parameter
+const produceModeHeader = "X-Produce-Mode"
+
+func SetupRoutesAndRegister(router *gin.Engine, kafka service.IKafkaService, limiter *RateLimiter) *gin.Engine {
 	// Define the routes for the application
-	router.POST("/produce", func(c *gin.Context) {
+	router.POST("/produce", limiter.Middleware(), func(c *gin.Context) {
 		produceMessage(c, kafka)
 	})
+	router.POST("/produce/batch", limiter.Middleware(), func(c *gin.Context) {
+		produceBatch(c, kafka)
+	})
+	router.POST("/topics/:topic/produce", limiter.Middleware(), func(c *gin.Context) {
+		produceMessageToTopic(c, kafka)
+	})
 	return router
 }
 
@@ -24,10 +40,98 @@ func produceMessage(ctx *gin.Context, kafkaService service.IKafkaService) {
 		return
 	}
 
-	kafkaService.ProduceMessage(message)
+	produce(ctx, kafkaService, message)
+}
+
+func produceMessageToTopic(ctx *gin.Context, kafkaService service.IKafkaService) {
+	var message model.ProduceMessageRequest
+
+	if err := ctx.ShouldBindJSON(&message); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	// the topic in the path takes precedence over one given in the body
+	message.Topic = ctx.Param("topic")
+
+	produce(ctx, kafkaService, message)
+}
+
+// produce sends the message either synchronously or fire-and-forget, depending on the requested mode
+func produce(ctx *gin.Context, kafkaService service.IKafkaService, message model.ProduceMessageRequest) {
+	if isSyncProduce(ctx) {
+		result, err := kafkaService.ProduceMessageSync(ctx.Request.Context(), message)
+		if err != nil {
+			logger.FromContext(ctx.Request.Context()).Warn("synchronous produce failed", "topic", message.Topic, "error", err)
+			ctx.JSON(statusForKafkaError(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx.JSON(http.StatusOK, result)
+		return
+	}
+
+	if err := kafkaService.ProduceMessage(message); err != nil {
+		logger.FromContext(ctx.Request.Context()).Warn("produce rejected", "topic", message.Topic, "error", err)
+		ctx.JSON(statusForKafkaError(err), gin.H{"error": err.Error()})
+		return
+	}
 
 	ctx.JSON(202, gin.H{
 		"message": "Message produced successfully!",
 	})
 
 }
+
+func produceBatch(ctx *gin.Context, kafkaService service.IKafkaService) {
+	var request model.ProduceBatchRequest
+
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	response := kafkaService.ProduceBatch(ctx.Request.Context(), request.Records)
+
+	status := http.StatusOK
+	if response.Failed > 0 {
+		status = http.StatusMultiStatus
+	}
+	ctx.JSON(status, response)
+}
+
+// isSyncProduce reports whether the caller asked to wait for the broker acknowledgement,
+// either with ?sync=true or with the X-Produce-Mode: sync header
+func isSyncProduce(ctx *gin.Context) bool {
+	if sync, err := strconv.ParseBool(ctx.Query("sync")); err == nil && sync {
+		return true
+	}
+
+	return strings.EqualFold(ctx.GetHeader(produceModeHeader), "sync")
+}
+
+// statusForKafkaError maps a produce or topic lookup error to the HTTP status returned to the caller
+func statusForKafkaError(err error) int {
+	switch {
+	case errors.Is(err, service.ErrTopicNotAllowed):
+		return http.StatusForbidden
+	case errors.Is(err, service.ErrTopicNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, kgo.ErrRecordTimeout):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, context.Canceled):
+		return 499 // client closed request
+	case errors.Is(err, kerr.MessageTooLarge), errors.Is(err, kerr.RecordListTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, kerr.InvalidRecord), errors.Is(err, kerr.CorruptMessage), errors.Is(err, kerr.InvalidTopicException):
+		return http.StatusBadRequest
+	case errors.Is(err, kerr.TopicAuthorizationFailed), errors.Is(err, kerr.ClusterAuthorizationFailed):
+		return http.StatusForbidden
+	case errors.Is(err, kerr.UnknownTopicOrPartition):
+		return http.StatusNotFound
+	case errors.Is(err, kgo.ErrMaxBuffered), kerr.IsRetriable(err):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusBadGateway
+	}
+}
@@ -0,0 +1,431 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/geo-gkez/go-pocs/redpanda-poc/internal/model"
+	"github.com/twmb/franz-go/pkg/kadm"
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// Group states in which no member is consuming, so offsets can be reset
+const (
+	groupStateEmpty = "Empty"
+	groupStateDead  = "Dead"
+)
+
+var (
+	// ErrTopicProtected is returned when deleting a topic the application itself depends on
+	ErrTopicProtected = errors.New("topic is used by this application and cannot be deleted")
+	// ErrGroupNotFound is returned when describing a consumer group that does not exist
+	ErrGroupNotFound = errors.New("consumer group does not exist")
+	// ErrGroupNotEmpty is returned when resetting the offsets of a group that still has members
+	ErrGroupNotEmpty = errors.New("consumer group has active members, stop them before resetting offsets")
+	// ErrInvalidOffsetReset is returned when an offset reset request cannot be applied
+	ErrInvalidOffsetReset = errors.New("invalid offset reset")
+)
+
+type IAdminService interface {
+	ListTopics(ctx context.Context) ([]model.TopicSummary, error)
+	DescribeTopic(ctx context.Context, topic string) (*model.TopicDescription, error)
+	CreateTopic(ctx context.Context, request model.CreateTopicRequest) (*model.TopicSummary, error)
+	DeleteTopic(ctx context.Context, topic string) error
+	ListGroups(ctx context.Context) ([]model.GroupSummary, error)
+	DescribeGroup(ctx context.Context, group string) (*model.GroupDescription, error)
+	ResetOffsets(ctx context.Context, group string, request model.ResetOffsetsRequest) (*model.ResetOffsetsResponse, error)
+}
+
+type adminService struct {
+	admin     *kadm.Client
+	protected []string
+}
+
+// NewAdminService creates the service backing the admin API. The protected topics, those
+// the application produces to, consumes from or declares, cannot be deleted through it.
+func NewAdminService(client *kgo.Client, protected ...string) IAdminService {
+	return &adminService{
+		admin:     kadm.NewClient(client),
+		protected: protected,
+	}
+}
+
+func (s *adminService) ListTopics(ctx context.Context) ([]model.TopicSummary, error) {
+	details, err := s.admin.ListTopics(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list topics: %w", err)
+	}
+
+	topics := make([]model.TopicSummary, 0, len(details))
+	for _, detail := range details.Sorted() {
+		if detail.Err != nil {
+			continue
+		}
+		topics = append(topics, topicSummary(detail))
+	}
+
+	return topics, nil
+}
+
+func (s *adminService) DescribeTopic(ctx context.Context, topic string) (*model.TopicDescription, error) {
+	detail, err := topicDetail(ctx, s.admin, topic)
+	if err != nil {
+		return nil, err
+	}
+
+	startOffsets, err := s.admin.ListStartOffsets(ctx, topic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list start offsets of topic %s: %w", topic, err)
+	}
+	endOffsets, err := s.admin.ListEndOffsets(ctx, topic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list end offsets of topic %s: %w", topic, err)
+	}
+
+	description := &model.TopicDescription{
+		TopicSummary:     topicSummary(detail),
+		PartitionDetails: make([]model.PartitionDescription, 0, len(detail.Partitions)),
+		Configs:          map[string]string{},
+	}
+	for _, partition := range detail.Partitions.Sorted() {
+		start, _ := startOffsets.Lookup(topic, partition.Partition)
+		end, _ := endOffsets.Lookup(topic, partition.Partition)
+
+		description.PartitionDetails = append(description.PartitionDetails, model.PartitionDescription{
+			Partition:   partition.Partition,
+			Leader:      partition.Leader,
+			Replicas:    partition.Replicas,
+			ISR:         partition.ISR,
+			StartOffset: start.Offset,
+			EndOffset:   end.Offset,
+		})
+	}
+
+	configs, err := s.admin.DescribeTopicConfigs(ctx, topic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe configs of topic %s: %w", topic, err)
+	}
+	resource, err := configs.On(topic, nil)
+	if err == nil {
+		err = resource.Err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe configs of topic %s: %w", topic, err)
+	}
+	for _, config := range resource.Configs {
+		if config.Value != nil {
+			description.Configs[config.Key] = *config.Value
+		}
+	}
+
+	return description, nil
+}
+
+func (s *adminService) CreateTopic(ctx context.Context, request model.CreateTopicRequest) (*model.TopicSummary, error) {
+	partitions := request.Partitions
+	if partitions == 0 {
+		partitions = -1 // broker default
+	}
+	replicationFactor := request.ReplicationFactor
+	if replicationFactor == 0 {
+		replicationFactor = -1 // broker default
+	}
+
+	configs := make(map[string]*string, len(request.Configs))
+	for key, value := range request.Configs {
+		configs[key] = &value
+	}
+
+	created, err := s.admin.CreateTopic(ctx, partitions, replicationFactor, configs, request.Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create topic %s: %w", request.Name, err)
+	}
+
+	return &model.TopicSummary{
+		Name:              request.Name,
+		Partitions:        int(created.NumPartitions),
+		ReplicationFactor: int(created.ReplicationFactor),
+	}, nil
+}
+
+func (s *adminService) DeleteTopic(ctx context.Context, topic string) error {
+	if slices.Contains(s.protected, topic) {
+		return fmt.Errorf("%w: %s", ErrTopicProtected, topic)
+	}
+
+	if _, err := s.admin.DeleteTopic(ctx, topic); err != nil {
+		if errors.Is(err, kerr.UnknownTopicOrPartition) {
+			return fmt.Errorf("%w: %s", ErrTopicNotFound, topic)
+		}
+		return fmt.Errorf("failed to delete topic %s: %w", topic, err)
+	}
+
+	return nil
+}
+
+func (s *adminService) ListGroups(ctx context.Context) ([]model.GroupSummary, error) {
+	listed, err := s.admin.ListGroups(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list consumer groups: %w", err)
+	}
+
+	groups := make([]model.GroupSummary, 0, len(listed))
+	for _, group := range listed.Sorted() {
+		groups = append(groups, model.GroupSummary{
+			Name:         group.Group,
+			State:        group.State,
+			ProtocolType: group.ProtocolType,
+		})
+	}
+
+	return groups, nil
+}
+
+func (s *adminService) DescribeGroup(ctx context.Context, group string) (*model.GroupDescription, error) {
+	lags, err := s.admin.Lag(ctx, group)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe consumer group %s: %w", group, err)
+	}
+
+	described, ok := lags[group]
+	if !ok || described.State == groupStateDead {
+		return nil, fmt.Errorf("%w: %s", ErrGroupNotFound, group)
+	}
+	if err := described.Error(); err != nil {
+		return nil, fmt.Errorf("failed to describe consumer group %s: %w", group, err)
+	}
+
+	description := &model.GroupDescription{
+		GroupSummary: model.GroupSummary{
+			Name:         described.Group,
+			State:        described.State,
+			ProtocolType: described.ProtocolType,
+		},
+		Protocol: described.Protocol,
+		Members:  make([]model.GroupMember, 0, len(described.Members)),
+		Lag:      make([]model.PartitionLag, 0),
+		TotalLag: described.Lag.Total(),
+	}
+
+	for _, member := range described.Members {
+		description.Members = append(description.Members, groupMember(member))
+	}
+
+	for _, lag := range described.Lag.Sorted() {
+		partitionLag := model.PartitionLag{
+			Topic:           lag.Topic,
+			Partition:       lag.Partition,
+			CommittedOffset: lag.Commit.At,
+			EndOffset:       lag.End.Offset,
+			Lag:             lag.Lag,
+		}
+		if lag.Member != nil {
+			partitionLag.MemberID = lag.Member.MemberID
+		}
+		if lag.Err != nil {
+			partitionLag.Error = lag.Err.Error()
+		}
+		description.Lag = append(description.Lag, partitionLag)
+	}
+
+	return description, nil
+}
+
+// ResetOffsets computes the new offsets of the group on the requested partitions and, unless
+// it is a dry run, commits them. Offsets can only be committed while the group has no members.
+func (s *adminService) ResetOffsets(ctx context.Context, group string, request model.ResetOffsetsRequest) (*model.ResetOffsetsResponse, error) {
+	detail, err := topicDetail(ctx, s.admin, request.Topic)
+	if err != nil {
+		return nil, err
+	}
+
+	partitions := request.Partitions
+	if len(partitions) == 0 {
+		partitions = detail.Partitions.Numbers()
+	}
+	for _, partition := range partitions {
+		if _, ok := detail.Partitions[partition]; !ok {
+			return nil, fmt.Errorf("%w: topic %s has no partition %d", ErrInvalidOffsetReset, request.Topic, partition)
+		}
+	}
+
+	if !request.DryRun {
+		if err := s.ensureGroupEmpty(ctx, group); err != nil {
+			return nil, err
+		}
+	}
+
+	targets, err := s.resetTargets(ctx, request, partitions)
+	if err != nil {
+		return nil, err
+	}
+
+	previous, err := s.admin.FetchOffsets(ctx, group)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch committed offsets of consumer group %s: %w", group, err)
+	}
+
+	response := &model.ResetOffsetsResponse{
+		Group:      group,
+		Topic:      request.Topic,
+		DryRun:     request.DryRun,
+		Partitions: make([]model.OffsetReset, 0, len(partitions)),
+	}
+
+	var committed kadm.OffsetResponses
+	if !request.DryRun {
+		if committed, err = s.admin.CommitOffsets(ctx, group, targets); err != nil {
+			return nil, fmt.Errorf("failed to commit offsets of consumer group %s: %w", group, err)
+		}
+	}
+
+	for _, target := range targets.Sorted() {
+		reset := model.OffsetReset{
+			Partition:      target.Partition,
+			PreviousOffset: -1,
+			NewOffset:      target.At,
+		}
+		if before, ok := previous.Lookup(target.Topic, target.Partition); ok && before.Err == nil {
+			reset.PreviousOffset = before.At
+		}
+		if result, ok := committed.Lookup(target.Topic, target.Partition); ok && result.Err != nil {
+			reset.Error = result.Err.Error()
+		}
+		response.Partitions = append(response.Partitions, reset)
+	}
+
+	return response, nil
+}
+
+// resetTargets resolves the offsets the partitions are reset to
+func (s *adminService) resetTargets(ctx context.Context, request model.ResetOffsetsRequest, partitions []int32) (kadm.Offsets, error) {
+	startOffsets, err := s.admin.ListStartOffsets(ctx, request.Topic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list start offsets of topic %s: %w", request.Topic, err)
+	}
+	endOffsets, err := s.admin.ListEndOffsets(ctx, request.Topic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list end offsets of topic %s: %w", request.Topic, err)
+	}
+
+	var listed kadm.ListedOffsets
+	switch request.To {
+	case model.ResetToEarliest:
+		listed = startOffsets
+	case model.ResetToLatest:
+		listed = endOffsets
+	case model.ResetToTimestamp:
+		if request.Timestamp == nil {
+			return nil, fmt.Errorf("%w: timestamp is required to reset to a timestamp", ErrInvalidOffsetReset)
+		}
+		if listed, err = s.admin.ListOffsetsAfterMilli(ctx, *request.Timestamp, request.Topic); err != nil {
+			return nil, fmt.Errorf("failed to list offsets of topic %s after %d: %w", request.Topic, *request.Timestamp, err)
+		}
+	case model.ResetToOffset:
+		if request.Offset == nil {
+			return nil, fmt.Errorf("%w: offset is required to reset to an offset", ErrInvalidOffsetReset)
+		}
+	default:
+		return nil, fmt.Errorf("%w: unknown target %q", ErrInvalidOffsetReset, request.To)
+	}
+
+	targets := make(kadm.Offsets)
+	for _, partition := range partitions {
+		start, _ := startOffsets.Lookup(request.Topic, partition)
+		end, _ := endOffsets.Lookup(request.Topic, partition)
+		if err := errors.Join(start.Err, end.Err); err != nil {
+			return nil, fmt.Errorf("failed to list offsets of %s/%d: %w", request.Topic, partition, err)
+		}
+
+		var at int64
+		if request.To == model.ResetToOffset {
+			at = *request.Offset
+			if at < start.Offset || at > end.Offset {
+				return nil, fmt.Errorf("%w: offset %d is outside of %s/%d, which holds offsets %d to %d",
+					ErrInvalidOffsetReset, at, request.Topic, partition, start.Offset, end.Offset)
+			}
+		} else {
+			offset, ok := listed.Lookup(request.Topic, partition)
+			if !ok {
+				return nil, fmt.Errorf("no offset listed for %s/%d", request.Topic, partition)
+			}
+			if offset.Err != nil {
+				return nil, fmt.Errorf("failed to list offsets of %s/%d: %w", request.Topic, partition, offset.Err)
+			}
+			at = offset.Offset
+		}
+
+		targets.Add(kadm.Offset{Topic: request.Topic, Partition: partition, At: at, LeaderEpoch: -1})
+	}
+
+	return targets, nil
+}
+
+// ensureGroupEmpty fails when the group has members, whose commits would overwrite the reset
+func (s *adminService) ensureGroupEmpty(ctx context.Context, group string) error {
+	described, err := s.admin.DescribeGroups(ctx, group)
+	if err != nil {
+		return fmt.Errorf("failed to describe consumer group %s: %w", group, err)
+	}
+
+	state := described[group]
+	if state.Err != nil {
+		return fmt.Errorf("failed to describe consumer group %s: %w", group, state.Err)
+	}
+	if state.State != groupStateEmpty && state.State != groupStateDead {
+		return fmt.Errorf("%w: %s is %s with %d members", ErrGroupNotEmpty, group, state.State, len(state.Members))
+	}
+
+	return nil
+}
+
+// topicDetail returns the metadata of a topic, or ErrTopicNotFound
+func topicDetail(ctx context.Context, admin *kadm.Client, topic string) (kadm.TopicDetail, error) {
+	details, err := admin.ListTopics(ctx, topic)
+	if err != nil {
+		return kadm.TopicDetail{}, fmt.Errorf("failed to describe topic %s: %w", topic, err)
+	}
+
+	detail := details[topic]
+	switch {
+	case errors.Is(detail.Err, kerr.UnknownTopicOrPartition):
+		return kadm.TopicDetail{}, fmt.Errorf("%w: %s", ErrTopicNotFound, topic)
+	case detail.Err != nil:
+		return kadm.TopicDetail{}, fmt.Errorf("failed to describe topic %s: %w", topic, detail.Err)
+	}
+
+	return detail, nil
+}
+
+func topicSummary(detail kadm.TopicDetail) model.TopicSummary {
+	return model.TopicSummary{
+		Name:              detail.Topic,
+		Partitions:        len(detail.Partitions),
+		ReplicationFactor: detail.Partitions.NumReplicas(),
+	}
+}
+
+func groupMember(member kadm.DescribedGroupMember) model.GroupMember {
+	groupMember := model.GroupMember{
+		MemberID:    member.MemberID,
+		ClientID:    member.ClientID,
+		ClientHost:  member.ClientHost,
+		Assignments: map[string][]int32{},
+	}
+	if member.InstanceID != nil {
+		groupMember.InstanceID = *member.InstanceID
+	}
+
+	if assignment, ok := member.Assigned.AsConsumer(); ok {
+		for _, topic := range assignment.Topics {
+			partitions := slices.Clone(topic.Partitions)
+			slices.Sort(partitions)
+			groupMember.Assignments[topic.Topic] = partitions
+		}
+	}
+
+	return groupMember
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/geo-gkez/go-pocs/redpanda-poc/internal/model"
+	"github.com/twmb/franz-go/pkg/kadm"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// readinessCheckTimeout bounds the broker round trip of a readiness check
+const readinessCheckTimeout = 2 * time.Second
+
+// Readiness check names as reported in the readiness response
+const (
+	brokersCheck       = "brokers"
+	consumerGroupCheck = "consumer-group"
+	produceBufferCheck = "produce-buffer"
+)
+
+// ConsumerGroup reports the membership of this instance's consumer in its consumer group
+type ConsumerGroup interface {
+	// GroupMetadata returns the member ID and generation, the member ID is empty until the group is joined
+	GroupMetadata() (string, int32)
+	// Assignments returns the partitions currently assigned to this member
+	Assignments() map[string][]int32
+}
+
+type IHealthService interface {
+	Readiness(ctx context.Context) model.ReadinessResponse
+}
+
+type healthService struct {
+	client          *kgo.Client
+	admin           *kadm.Client
+	group           ConsumerGroup
+	bufferThreshold int64
+}
+
+// NewHealthService creates the service backing the readiness endpoint. The produce buffer
+// check fails once bufferThreshold or more records wait to be produced.
+func NewHealthService(client *kgo.Client, group ConsumerGroup, bufferThreshold int64) IHealthService {
+	return &healthService{
+		client:          client,
+		admin:           kadm.NewClient(client),
+		group:           group,
+		bufferThreshold: bufferThreshold,
+	}
+}
+
+// Readiness runs every readiness check; the service is ready only if all of them pass
+func (s *healthService) Readiness(ctx context.Context) model.ReadinessResponse {
+	checks := map[string]model.CheckResult{
+		brokersCheck:       s.checkBrokers(ctx),
+		consumerGroupCheck: s.checkConsumerGroup(),
+		produceBufferCheck: s.checkProduceBuffer(),
+	}
+
+	status := model.StatusUp
+	for _, check := range checks {
+		if check.Status != model.StatusUp {
+			status = model.StatusDown
+		}
+	}
+
+	return model.ReadinessResponse{Status: status, Checks: checks}
+}
+
+func (s *healthService) checkBrokers(ctx context.Context) model.CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, readinessCheckTimeout)
+	defer cancel()
+
+	brokers, err := s.admin.ListBrokers(ctx)
+	if err != nil {
+		return model.CheckResult{Status: model.StatusDown, Details: fmt.Sprintf("failed to fetch broker metadata: %v", err)}
+	}
+	if len(brokers) == 0 {
+		return model.CheckResult{Status: model.StatusDown, Details: "no brokers reachable"}
+	}
+
+	return model.CheckResult{Status: model.StatusUp, Details: fmt.Sprintf("%d brokers reachable", len(brokers))}
+}
+
+func (s *healthService) checkConsumerGroup() model.CheckResult {
+	memberID, generation := s.group.GroupMetadata()
+	if memberID == "" {
+		return model.CheckResult{Status: model.StatusDown, Details: "consumer group not joined"}
+	}
+
+	partitions := 0
+	for _, assigned := range s.group.Assignments() {
+		partitions += len(assigned)
+	}
+	// a group with more members than partitions leaves some members idle; they can still serve
+	// the HTTP API and take over partitions on the next rebalance
+	if partitions == 0 {
+		return model.CheckResult{Status: model.StatusUp, Details: fmt.Sprintf("member %s (generation %d) holds no partitions", memberID, generation)}
+	}
+
+	return model.CheckResult{Status: model.StatusUp, Details: fmt.Sprintf("member %s (generation %d) holds %d partitions", memberID, generation, partitions)}
+}
+
+func (s *healthService) checkProduceBuffer() model.CheckResult {
+	buffered := s.client.BufferedProduceRecords()
+	if buffered >= s.bufferThreshold {
+		return model.CheckResult{Status: model.StatusDown, Details: fmt.Sprintf("%d records buffered, threshold is %d", buffered, s.bufferThreshold)}
+	}
+
+	return model.CheckResult{Status: model.StatusUp, Details: fmt.Sprintf("%d records buffered", buffered)}
+}
@@ -0,0 +1,39 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/geo-gkez/go-pocs/redpanda-poc/internal/model"
+)
+
+// testGroup is a consumer group member with fixed metadata and assignments
+type testGroup struct {
+	memberID    string
+	assignments map[string][]int32
+}
+
+func (g testGroup) GroupMetadata() (string, int32) { return g.memberID, 1 }
+
+func (g testGroup) Assignments() map[string][]int32 { return g.assignments }
+
+func TestCheckConsumerGroup(t *testing.T) {
+	tests := []struct {
+		name  string
+		group testGroup
+		want  string
+	}{
+		{"not joined", testGroup{}, model.StatusDown},
+		{"joined without partitions", testGroup{memberID: "member-1"}, model.StatusUp},
+		{"joined with an empty assignment", testGroup{memberID: "member-1", assignments: map[string][]int32{"test.input": {}}}, model.StatusUp},
+		{"holding partitions", testGroup{memberID: "member-1", assignments: map[string][]int32{"test.input": {0, 2}}}, model.StatusUp},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := &healthService{group: test.group}
+			if got := s.checkConsumerGroup(); got.Status != test.want {
+				t.Errorf("checkConsumerGroup = %s (%s), want %s", got.Status, got.Details, test.want)
+			}
+		})
+	}
+}
@@ -1,11 +1,46 @@
 package service
 
-import "fmt"
+import (
+	"context"
+	"log/slog"
 
-func ProcessKafkaMessage(key, value []byte) error {
+	"github.com/twmb/franz-go/pkg/kgo"
+)
 
-	fmt.Printf("Processing Kafka message with key: %s, value: %s\n", key, value)
+func ProcessKafkaMessage(ctx context.Context, rec *kgo.Record) error {
+
+	headers := make(map[string]string, len(rec.Headers))
+	for _, header := range rec.Headers {
+		headers[header.Key] = string(header.Value)
+	}
+
+	slog.InfoContext(ctx, "processing Kafka message",
+		"topic", rec.Topic,
+		"partition", rec.Partition,
+		"offset", rec.Offset,
+		"timestamp", rec.Timestamp,
+		"key", string(rec.Key),
+		"value", string(rec.Value),
+		"headers", headers,
+	)
 	//TODO: Add processing logic
 
 	return nil
 }
+
+// TransformKafkaMessage processes a record consumed in transactional mode and returns the
+// records derived from it, which are produced to the default producer topic in the same
+// transaction that commits the consumed record
+func TransformKafkaMessage(ctx context.Context, rec *kgo.Record) ([]*kgo.Record, error) {
+	if err := ProcessKafkaMessage(ctx, rec); err != nil {
+		return nil, err
+	}
+	//TODO: Add transformation logic
+
+	derived := &kgo.Record{
+		Key:     rec.Key,
+		Value:   rec.Value,
+		Headers: rec.Headers,
+	}
+	return []*kgo.Record{derived}, nil
+}
@@ -2,39 +2,245 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/geo-gkez/go-pocs/redpanda-poc/internal/config/models"
 	"github.com/geo-gkez/go-pocs/redpanda-poc/internal/model"
+	"github.com/twmb/franz-go/pkg/kadm"
+	"github.com/twmb/franz-go/pkg/kerr"
 	"github.com/twmb/franz-go/pkg/kgo"
 )
 
+// defaultProduceTimeout bounds how long a single produce may wait for the broker
+// when kafka.producer.timeout is not configured
+const defaultProduceTimeout = 5 * time.Second
+
 type IKafkaService interface {
 	ProduceMessage(message model.ProduceMessageRequest) error
+	ProduceMessageSync(ctx context.Context, message model.ProduceMessageRequest) (*model.ProduceMessageResponse, error)
+	ProduceBatch(ctx context.Context, records []model.BatchRecord) model.ProduceBatchResponse
+	SetProduceTimeout(timeout time.Duration)
 }
 
+var (
+	// ErrTopicNotAllowed is returned when a caller targets a topic outside the producer allowlist
+	ErrTopicNotAllowed = errors.New("topic is not allowed for producing")
+	// ErrTopicNotFound is returned when a caller targets an allowed topic that does not exist
+	ErrTopicNotFound = errors.New("topic does not exist")
+)
+
 type kafkaService struct {
-	client *kgo.Client
+	client       *kgo.Client
+	admin        *kadm.Client
+	defaultTopic string
+	allowed      map[string]struct{}
+	knownTopics  sync.Map     // topics already confirmed to exist on the cluster
+	timeout      atomic.Int64 // produce timeout in nanoseconds, changed on configuration reload
 }
 
-func NewKafkaService(client *kgo.Client) IKafkaService {
-	return &kafkaService{client: client}
+func NewKafkaService(client *kgo.Client, topics config_models.KafkaTopics) IKafkaService {
+	allowed := make(map[string]struct{}, len(topics.AllowedProducerTopics)+1)
+	allowed[topics.DefaultProducer] = struct{}{}
+	for _, topic := range topics.AllowedProducerTopics {
+		allowed[topic] = struct{}{}
+	}
+
+	s := &kafkaService{
+		client:       client,
+		admin:        kadm.NewClient(client),
+		defaultTopic: topics.DefaultProducer,
+		allowed:      allowed,
+	}
+	s.SetProduceTimeout(defaultProduceTimeout)
+
+	return s
+}
+
+// SetProduceTimeout changes how long subsequent produces may wait for the broker;
+// a zero timeout restores the default
+func (s *kafkaService) SetProduceTimeout(timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = defaultProduceTimeout
+	}
+	s.timeout.Store(int64(timeout))
+}
+
+func (s *kafkaService) produceTimeout() time.Duration {
+	return time.Duration(s.timeout.Load())
 }
 
 func (s *kafkaService) ProduceMessage(message model.ProduceMessageRequest) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), s.produceTimeout())
+
+	topic, err := s.resolveTopic(ctx, message.Topic)
+	if err != nil {
+		cancel()
+		return err
+	}
 
 	// fire and forget approach
-	record := &kgo.Record{Key: []byte(message.Key), Value: []byte(message.Message)}
+	record := newRecord(topic, message)
 	s.client.Produce(ctx, record, func(r *kgo.Record, err error) {
 		defer cancel()
 		if err != nil {
-			fmt.Printf("record had a produce error: %v\n", err)
+			slog.Error("record had a produce error", "topic", r.Topic, "error", err)
 		} else {
-			fmt.Printf("Successfully produced record to %s [%d] at offset %d\n",
-				r.Topic, r.Partition, r.Offset)
+			slog.Debug("successfully produced record", "topic", r.Topic, "partition", r.Partition, "offset", r.Offset)
 		}
 	})
 
 	return nil
 }
+
+// ProduceMessageSync produces the message and waits for the broker to acknowledge it,
+// returning the partition and offset the record was written to
+func (s *kafkaService) ProduceMessageSync(ctx context.Context, message model.ProduceMessageRequest) (*model.ProduceMessageResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.produceTimeout())
+	defer cancel()
+
+	topic, err := s.resolveTopic(ctx, message.Topic)
+	if err != nil {
+		return nil, err
+	}
+
+	record := newRecord(topic, message)
+	r, err := s.client.ProduceSync(ctx, record).First()
+	if err != nil {
+		return nil, fmt.Errorf("failed to produce record: %w", err)
+	}
+
+	return &model.ProduceMessageResponse{
+		Topic:     r.Topic,
+		Partition: r.Partition,
+		Offset:    r.Offset,
+		Timestamp: r.Timestamp,
+	}, nil
+}
+
+// ProduceBatch produces all records through the shared client and waits for every
+// acknowledgement, reporting the outcome of each record in request order
+func (s *kafkaService) ProduceBatch(ctx context.Context, records []model.BatchRecord) model.ProduceBatchResponse {
+	ctx, cancel := context.WithTimeout(ctx, s.produceTimeout())
+	defer cancel()
+
+	results := make([]model.ProduceRecordResult, len(records))
+
+	var wg sync.WaitGroup
+	wg.Add(len(records))
+	for i, rec := range records {
+		topic, err := s.resolveTopic(ctx, rec.Topic)
+		if err != nil {
+			results[i] = model.ProduceRecordResult{Index: i, Topic: rec.Topic, Partition: -1, Offset: -1, Error: err.Error()}
+			wg.Done()
+			continue
+		}
+
+		record := &kgo.Record{
+			Topic:   topic,
+			Key:     []byte(rec.Key),
+			Value:   []byte(rec.Value),
+			Headers: toRecordHeaders(rec.Headers),
+		}
+		if rec.Partition != nil {
+			record.Partition = *rec.Partition
+			record.Context = withExplicitPartition(ctx)
+		}
+
+		s.client.Produce(ctx, record, func(r *kgo.Record, err error) {
+			defer wg.Done()
+
+			if err != nil {
+				// a failed record has no location, so it is reported with negative partition and offset
+				results[i] = model.ProduceRecordResult{Index: i, Topic: r.Topic, Partition: -1, Offset: -1, Error: err.Error()}
+				return
+			}
+			results[i] = model.ProduceRecordResult{
+				Index:     i,
+				Topic:     r.Topic,
+				Partition: r.Partition,
+				Offset:    r.Offset,
+				Timestamp: r.Timestamp,
+			}
+		})
+	}
+	wg.Wait()
+
+	response := model.ProduceBatchResponse{Results: results}
+	for _, result := range results {
+		if result.Error != "" {
+			response.Failed++
+		} else {
+			response.Succeeded++
+		}
+	}
+
+	return response
+}
+
+// resolveTopic returns the topic a request should be produced to, rejecting topics outside
+// the allowlist and topics that do not exist on the cluster
+func (s *kafkaService) resolveTopic(ctx context.Context, topic string) (string, error) {
+	if topic == "" {
+		return s.defaultTopic, nil
+	}
+
+	if _, ok := s.allowed[topic]; !ok {
+		return "", fmt.Errorf("%w: %s", ErrTopicNotAllowed, topic)
+	}
+
+	if _, ok := s.knownTopics.Load(topic); ok {
+		return topic, nil
+	}
+
+	details, err := s.admin.ListTopics(ctx, topic)
+	if err != nil {
+		return "", fmt.Errorf("failed to look up topic %s: %w", topic, err)
+	}
+
+	detail, ok := details[topic]
+	if !ok || errors.Is(detail.Err, kerr.UnknownTopicOrPartition) {
+		return "", fmt.Errorf("%w: %s", ErrTopicNotFound, topic)
+	}
+	if detail.Err != nil {
+		return "", fmt.Errorf("failed to look up topic %s: %w", topic, detail.Err)
+	}
+
+	s.knownTopics.Store(topic, struct{}{})
+	return topic, nil
+}
+
+// newRecord builds the Kafka record for a single produce request
+func newRecord(topic string, message model.ProduceMessageRequest) *kgo.Record {
+	return &kgo.Record{
+		Topic:   topic,
+		Key:     []byte(message.Key),
+		Value:   []byte(message.Message),
+		Headers: toRecordHeaders(message.Headers),
+	}
+}
+
+// toRecordHeaders converts a headers map to Kafka record headers, sorted by key
+func toRecordHeaders(headers map[string]string) []kgo.RecordHeader {
+	if len(headers) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(headers))
+	for key := range headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	recordHeaders := make([]kgo.RecordHeader, 0, len(keys))
+	for _, key := range keys {
+		recordHeaders = append(recordHeaders, kgo.RecordHeader{Key: key, Value: []byte(headers[key])})
+	}
+
+	return recordHeaders
+}
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"context"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// explicitPartitionKey marks a record context whose Partition field was chosen by the caller
+type explicitPartitionKey struct{}
+
+// withExplicitPartition flags the records produced with ctx as carrying a caller-chosen partition
+func withExplicitPartition(ctx context.Context) context.Context {
+	return context.WithValue(ctx, explicitPartitionKey{}, true)
+}
+
+func hasExplicitPartition(r *kgo.Record) bool {
+	if r.Context == nil {
+		return false
+	}
+	explicit, _ := r.Context.Value(explicitPartitionKey{}).(bool)
+	return explicit
+}
+
+// RecordPartitioner returns a partitioner that honours partitions requested by the caller
+// and otherwise falls back to franz-go's default partitioning
+func RecordPartitioner() kgo.Partitioner {
+	return &recordPartitioner{
+		fallback: kgo.UniformBytesPartitioner(64<<10, true, true, nil),
+	}
+}
+
+type recordPartitioner struct {
+	fallback kgo.Partitioner
+}
+
+func (p *recordPartitioner) ForTopic(topic string) kgo.TopicPartitioner {
+	return &topicRecordPartitioner{fallback: p.fallback.ForTopic(topic)}
+}
+
+// topicRecordPartitioner forwards the optional extension interfaces of the fallback
+// partitioner so the default sticky batching keeps working for unpinned records
+type topicRecordPartitioner struct {
+	fallback kgo.TopicPartitioner
+}
+
+func (p *topicRecordPartitioner) RequiresConsistency(r *kgo.Record) bool {
+	// explicit partitions index into the full partition list, not only the writable ones
+	return hasExplicitPartition(r) || p.fallback.RequiresConsistency(r)
+}
+
+func (p *topicRecordPartitioner) Partition(r *kgo.Record, n int) int {
+	if hasExplicitPartition(r) {
+		return int(r.Partition)
+	}
+	return p.fallback.Partition(r, n)
+}
+
+func (p *topicRecordPartitioner) PartitionByBackup(r *kgo.Record, n int, backup kgo.TopicBackupIter) int {
+	if hasExplicitPartition(r) {
+		return int(r.Partition)
+	}
+	if bp, ok := p.fallback.(kgo.TopicBackupPartitioner); ok {
+		return bp.PartitionByBackup(r, n, backup)
+	}
+	return p.fallback.Partition(r, n)
+}
+
+func (p *topicRecordPartitioner) OnNewBatch() {
+	if nb, ok := p.fallback.(kgo.TopicPartitionerOnNewBatch); ok {
+		nb.OnNewBatch()
+	}
+}
@@ -0,0 +1,249 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/geo-gkez/go-pocs/redpanda-poc/internal/model"
+	"github.com/twmb/franz-go/pkg/kadm"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// Bounds of a record read
+const (
+	defaultReadLimit   = 100
+	maxReadLimit       = 1000
+	defaultReadTimeout = 2 * time.Second
+	maxReadTimeout     = 10 * time.Second
+)
+
+var (
+	// ErrPartitionNotFound is returned when reading from a partition the topic does not have
+	ErrPartitionNotFound = errors.New("partition does not exist")
+	// ErrInvalidRecordQuery is returned when a record query is contradictory
+	ErrInvalidRecordQuery = errors.New("invalid record query")
+)
+
+// ClientFactory creates a Kafka client connected to the cluster of the application,
+// with the given options added
+type ClientFactory func(opts ...kgo.Opt) (*kgo.Client, error)
+
+type IRecordService interface {
+	ReadRecords(ctx context.Context, topic string, query model.ReadRecordsQuery) (*model.ReadRecordsResponse, error)
+	TailRecords(ctx context.Context, topic string, query model.TailRecordsQuery) (*RecordTail, error)
+	StopTails()
+}
+
+type recordService struct {
+	admin     *kadm.Client
+	newClient ClientFactory
+	tails     chan struct{} // one slot per running tail
+	stopped   context.Context
+	stopTails context.CancelFunc
+}
+
+// NewRecordService creates the service reading records for inspection. Every read and tail
+// uses its own short-lived client outside of any consumer group, so the consumer group of
+// the application is never affected. At most maxTails tails run at the same time.
+func NewRecordService(client *kgo.Client, newClient ClientFactory, maxTails int) IRecordService {
+	stopped, stopTails := context.WithCancel(context.Background())
+
+	return &recordService{
+		admin:     kadm.NewClient(client),
+		newClient: newClient,
+		tails:     make(chan struct{}, maxTails),
+		stopped:   stopped,
+		stopTails: stopTails,
+	}
+}
+
+// ReadRecords reads the records of the query range from one partition, waiting at most
+// the query timeout for them
+func (s *recordService) ReadRecords(ctx context.Context, topic string, query model.ReadRecordsQuery) (*model.ReadRecordsResponse, error) {
+	limit, timeout, err := readBounds(query)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start, end, err := s.partitionRange(ctx, topic, query.Partition)
+	if err != nil {
+		return nil, err
+	}
+
+	from, err := s.startOffset(ctx, topic, query, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	until := end
+	if query.ToOffset != nil && *query.ToOffset < until {
+		until = *query.ToOffset
+	}
+
+	response := &model.ReadRecordsResponse{
+		Topic:      topic,
+		Partition:  query.Partition,
+		Records:    []model.ConsumedRecord{},
+		NextOffset: from,
+	}
+	if from >= until {
+		return response, nil
+	}
+
+	client, err := s.newClient(kgo.ConsumePartitions(map[string]map[int32]kgo.Offset{
+		topic: {query.Partition: kgo.NewOffset().At(from)},
+	}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create consumer: %w", err)
+	}
+	defer client.Close()
+
+	for {
+		fetches := client.PollFetches(ctx)
+		if ctx.Err() != nil {
+			response.TimedOut = true
+			return response, nil
+		}
+		if err := fetches.Err0(); err != nil {
+			return nil, fmt.Errorf("failed to fetch records: %w", err)
+		}
+
+		for iter := fetches.RecordIter(); !iter.Done(); {
+			rec := iter.Next()
+			if rec.Offset >= until || (query.ToTimestamp != nil && rec.Timestamp.UnixMilli() > *query.ToTimestamp) {
+				return response, nil
+			}
+
+			response.Records = append(response.Records, consumedRecord(rec))
+			response.NextOffset = rec.Offset + 1
+			if len(response.Records) >= limit || response.NextOffset >= until {
+				return response, nil
+			}
+		}
+	}
+}
+
+// readBounds applies the defaults and maximums of the record count and the wait
+func readBounds(query model.ReadRecordsQuery) (int, time.Duration, error) {
+	set := 0
+	for _, isSet := range []bool{query.FromOffset != nil, query.FromTimestamp != nil, query.Last != nil} {
+		if isSet {
+			set++
+		}
+	}
+	if set > 1 {
+		return 0, 0, fmt.Errorf("%w: only one of fromOffset, fromTimestamp and last may be given", ErrInvalidRecordQuery)
+	}
+
+	limit := query.Limit
+	if query.Last != nil {
+		limit = *query.Last
+	}
+	if limit <= 0 {
+		limit = defaultReadLimit
+	}
+	if limit > maxReadLimit {
+		return 0, 0, fmt.Errorf("%w: at most %d records can be read at once", ErrInvalidRecordQuery, maxReadLimit)
+	}
+
+	timeout := query.Timeout
+	if timeout <= 0 {
+		timeout = defaultReadTimeout
+	}
+	if timeout > maxReadTimeout {
+		return 0, 0, fmt.Errorf("%w: the timeout can be at most %s", ErrInvalidRecordQuery, maxReadTimeout)
+	}
+
+	return limit, timeout, nil
+}
+
+// partitionRange returns the start and end offsets of the partition, or an error when
+// the topic or partition does not exist
+func (s *recordService) partitionRange(ctx context.Context, topic string, partition int32) (int64, int64, error) {
+	detail, err := topicDetail(ctx, s.admin, topic)
+	if err != nil {
+		return 0, 0, err
+	}
+	if _, ok := detail.Partitions[partition]; !ok {
+		return 0, 0, fmt.Errorf("%w: %s/%d", ErrPartitionNotFound, topic, partition)
+	}
+
+	startOffsets, err := s.admin.ListStartOffsets(ctx, topic)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to list start offsets of topic %s: %w", topic, err)
+	}
+	endOffsets, err := s.admin.ListEndOffsets(ctx, topic)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to list end offsets of topic %s: %w", topic, err)
+	}
+
+	start, _ := startOffsets.Lookup(topic, partition)
+	end, _ := endOffsets.Lookup(topic, partition)
+	if err := errors.Join(start.Err, end.Err); err != nil {
+		return 0, 0, fmt.Errorf("failed to list offsets of %s/%d: %w", topic, partition, err)
+	}
+
+	return start.Offset, end.Offset, nil
+}
+
+// startOffset returns the first offset to read, within the start and end of the partition
+func (s *recordService) startOffset(ctx context.Context, topic string, query model.ReadRecordsQuery, start, end int64) (int64, error) {
+	switch {
+	case query.FromOffset != nil:
+		return min(max(*query.FromOffset, start), end), nil
+	case query.Last != nil:
+		return max(end-int64(*query.Last), start), nil
+	case query.FromTimestamp != nil:
+		listed, err := s.admin.ListOffsetsAfterMilli(ctx, *query.FromTimestamp, topic)
+		if err != nil {
+			return 0, fmt.Errorf("failed to list offsets of topic %s after %d: %w", topic, *query.FromTimestamp, err)
+		}
+		offset, ok := listed.Lookup(topic, query.Partition)
+		if !ok || offset.Err != nil {
+			return 0, fmt.Errorf("failed to list offsets of %s/%d after %d: %w", topic, query.Partition, *query.FromTimestamp, offset.Err)
+		}
+		return offset.Offset, nil
+	default:
+		return start, nil
+	}
+}
+
+// consumedRecord converts a record for a JSON response
+func consumedRecord(rec *kgo.Record) model.ConsumedRecord {
+	consumed := model.ConsumedRecord{
+		Topic:     rec.Topic,
+		Partition: rec.Partition,
+		Offset:    rec.Offset,
+		Timestamp: rec.Timestamp,
+		Key:       optionalPayload(rec.Key),
+		Value:     optionalPayload(rec.Value),
+		Headers:   make([]model.ConsumedHeader, 0, len(rec.Headers)),
+	}
+	for _, header := range rec.Headers {
+		consumed.Headers = append(consumed.Headers, model.ConsumedHeader{Key: header.Key, Value: payload(header.Value)})
+	}
+	return consumed
+}
+
+func optionalPayload(data []byte) *model.Payload {
+	if data == nil {
+		return nil
+	}
+	p := payload(data)
+	return &p
+}
+
+// payload keeps UTF-8 data readable and base64-encodes anything else
+func payload(data []byte) model.Payload {
+	if utf8.Valid(data) {
+		return model.Payload{Encoding: model.EncodingUTF8, Data: string(data)}
+	}
+	return model.Payload{Encoding: model.EncodingBase64, Data: base64.StdEncoding.EncodeToString(data)}
+}
@@ -0,0 +1,173 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/geo-gkez/go-pocs/redpanda-poc/internal/model"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// ErrTooManyTails is returned when the maximum number of concurrent tails is running
+var ErrTooManyTails = errors.New("too many live tails are running")
+
+// RecordTail is a running live tail of a topic. Records delivers the matching records
+// until the tail ends, then it is closed and Err reports why the tail failed, if it did.
+type RecordTail struct {
+	records chan model.ConsumedRecord
+	err     error
+}
+
+// Records delivers the tailed records; it is closed once the tail ends
+func (t *RecordTail) Records() <-chan model.ConsumedRecord {
+	return t.records
+}
+
+// Err returns the error that ended the tail; only valid once Records is closed
+func (t *RecordTail) Err() error {
+	return t.err
+}
+
+// recordFilter matches records on their key and headers
+type recordFilter struct {
+	key     *string
+	headers []kgo.RecordHeader
+}
+
+// TailRecords starts tailing the topic with its own client, from the end of the partitions
+// or from the query offset. The tail runs until ctx is done or StopTails is called, and
+// the client is closed when it ends.
+func (s *recordService) TailRecords(ctx context.Context, topic string, query model.TailRecordsQuery) (*RecordTail, error) {
+	filter, err := newRecordFilter(query)
+	if err != nil {
+		return nil, err
+	}
+
+	detail, err := topicDetail(ctx, s.admin, topic)
+	if err != nil {
+		return nil, err
+	}
+	if query.Partition != nil {
+		if _, ok := detail.Partitions[*query.Partition]; !ok {
+			return nil, fmt.Errorf("%w: %s/%d", ErrPartitionNotFound, topic, *query.Partition)
+		}
+	}
+
+	select {
+	case s.tails <- struct{}{}:
+	default:
+		return nil, fmt.Errorf("%w: at most %d", ErrTooManyTails, cap(s.tails))
+	}
+
+	start := kgo.NewOffset().AtEnd()
+	if query.FromOffset != nil {
+		start = kgo.NewOffset().At(*query.FromOffset)
+	}
+
+	opts := []kgo.Opt{kgo.ConsumeTopics(topic), kgo.ConsumeResetOffset(start)}
+	if query.Partition != nil {
+		opts = []kgo.Opt{kgo.ConsumePartitions(map[string]map[int32]kgo.Offset{
+			topic: {*query.Partition: start},
+		})}
+	}
+
+	client, err := s.newClient(opts...)
+	if err != nil {
+		<-s.tails
+		return nil, fmt.Errorf("failed to create consumer: %w", err)
+	}
+
+	tail := &RecordTail{records: make(chan model.ConsumedRecord)}
+
+	// the request context ends the tail when the client disconnects, StopTails on shutdown
+	ctx, cancel := context.WithCancel(ctx)
+	stopOnShutdown := context.AfterFunc(s.stopped, cancel)
+
+	go func() {
+		defer func() {
+			stopOnShutdown()
+			cancel()
+			client.Close()
+			<-s.tails
+			close(tail.records)
+		}()
+
+		slog.Debug("live tail started", "topic", topic)
+		tail.err = tailRecords(ctx, client, filter, tail.records)
+		slog.Debug("live tail stopped", "topic", topic, "error", tail.err)
+	}()
+
+	return tail, nil
+}
+
+// StopTails ends every running tail, so that long-lived streams do not hold up a shutdown
+func (s *recordService) StopTails() {
+	s.stopTails()
+}
+
+// tailRecords polls the client and sends the matching records until ctx is done
+func tailRecords(ctx context.Context, client *kgo.Client, filter recordFilter, records chan<- model.ConsumedRecord) error {
+	for {
+		fetches := client.PollFetches(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err := fetches.Err0(); err != nil {
+			return fmt.Errorf("failed to fetch records: %w", err)
+		}
+
+		for iter := fetches.RecordIter(); !iter.Done(); {
+			rec := iter.Next()
+			if !filter.matches(rec) {
+				continue
+			}
+
+			select {
+			case records <- consumedRecord(rec):
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}
+}
+
+// newRecordFilter parses the key and header filters of a tail query
+func newRecordFilter(query model.TailRecordsQuery) (recordFilter, error) {
+	filter := recordFilter{key: query.Key}
+
+	for _, header := range query.Headers {
+		key, value, ok := strings.Cut(header, ":")
+		if !ok || key == "" {
+			return recordFilter{}, fmt.Errorf("%w: header filter %q is not in name:value form", ErrInvalidRecordQuery, header)
+		}
+		filter.headers = append(filter.headers, kgo.RecordHeader{Key: key, Value: []byte(value)})
+	}
+
+	return filter, nil
+}
+
+// matches reports whether the record has the filtered key and every filtered header
+func (f recordFilter) matches(rec *kgo.Record) bool {
+	if f.key != nil && string(rec.Key) != *f.key {
+		return false
+	}
+
+	for _, wanted := range f.headers {
+		found := false
+		for _, header := range rec.Headers {
+			if header.Key == wanted.Key && bytes.Equal(header.Value, wanted.Value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}